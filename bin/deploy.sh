@@ -20,5 +20,4 @@ helm upgrade --install bridge-server \
     --set postgresql.auth.postgresPassword=$DB_ROOT_PASSWORD \
     --set postgresql.auth.username=$DB_USER \
     --set postgresql.auth.password=$DB_PASSWORD \
-    --set-file db_init=db/migrations/create_tables.sql \
     ./chart/
\ No newline at end of file
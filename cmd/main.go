@@ -1,54 +1,209 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/mrbttf/bridge-server/db/fixtures"
 	"github.com/mrbttf/bridge-server/pkg/config"
+	"github.com/mrbttf/bridge-server/pkg/core"
 	"github.com/mrbttf/bridge-server/pkg/core/services/auth"
+	"github.com/mrbttf/bridge-server/pkg/core/services/match"
+	"github.com/mrbttf/bridge-server/pkg/core/services/policy"
 	"github.com/mrbttf/bridge-server/pkg/core/services/room"
 	"github.com/mrbttf/bridge-server/pkg/core/services/session"
 	"github.com/mrbttf/bridge-server/pkg/db"
+	"github.com/mrbttf/bridge-server/pkg/events"
 	"github.com/mrbttf/bridge-server/pkg/log"
+	"github.com/mrbttf/bridge-server/pkg/mail"
+	"github.com/mrbttf/bridge-server/pkg/ratelimit"
 	"github.com/mrbttf/bridge-server/pkg/repositories"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/mrbttf/bridge-server/pkg/repositories/sqlite"
 	"github.com/mrbttf/bridge-server/pkg/server"
 )
 
 func main() {
-	port, ok := os.LookupEnv("PORT")
-	if !ok {
-		port = "8080"
-		log.Info("Using default port 8080")
-	}
 	config, err := config.GetConfig(os.Getenv("ENV"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	postgresDB, err := db.New(&config)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = migrate(&config, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	port, ok := os.LookupEnv("PORT")
+	if !ok {
+		port = "8080"
+		log.Info("Using default port 8080")
 	}
-	defer postgresDB.Close()
 
-	repository := repositories.NewSessionRepository(postgresDB)
-	playerRepository := repositories.NewPlayerRepository(postgresDB)
-	userRepository := repositories.NewUserRepository(postgresDB)
-	roomRepository := repositories.NewRoomRepository(postgresDB)
-	serviceSession := session.New(
-		repository,
-		playerRepository,
-		userRepository,
-		roomRepository,
-	)
+	var repos core.Repositories
+	var unitOfWork core.UnitOfWork
+	if config.InMemory() {
+		store := memory.NewStore()
+		repos, unitOfWork = store.Repositories(), store
+		log.Info("Using in-memory storage, data is lost on exit")
+	} else {
+		sqlDB, err := db.New(&config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sqlDB.Close()
+		if config.SQLite() {
+			repos, unitOfWork = sqlite.NewRepositories(sqlDB), sqlite.NewUnitOfWork(sqlDB)
+		} else {
+			repos, unitOfWork = repositories.NewRepositories(sqlDB), repositories.NewUnitOfWork(sqlDB)
+		}
+	}
+	sessionEvents := events.NewSessionBus()
+	roomEvents := events.NewRoomBus()
 	roomService := room.New(
-		roomRepository,
-		userRepository,
+		repos.Rooms,
+		repos.Users,
+		roomEvents,
+	)
+	serviceSession := session.New(
+		unitOfWork,
+		sessionEvents,
+		roomService,
 	)
+	tokenKey := []byte(config.TokenKey)
+	if len(tokenKey) == 0 {
+		tokenKey = make([]byte, 32)
+		_, err = rand.Read(tokenKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Warn("TOKEN_KEY is not set, users have to log in again after a restart")
+	}
+	var mailer core.Mailer
+	if config.SMTPHost != "" {
+		mailer = mail.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUser, config.SMTPPassword, config.MailFrom)
+	} else {
+		mailer = mail.NewFileMailer(config.MailDir, config.MailFrom)
+		log.Warn("SMTP_HOST is not set, emails are not sent")
+	}
 	authService := auth.New(
-		userRepository,
+		unitOfWork,
+		repos.Users,
+		repos.Tokens,
+		repos.ActionTokens,
+		mailer,
+		tokenKey,
+		config.AccessTokenLifetime,
+		config.AppURL,
+	)
+	matchService := match.New(
+		unitOfWork,
+		serviceSession,
+	)
+	policy := policy.New(
+		repos.Users,
+		repos.Rooms,
+		repos.Sessions,
+		repos.Matches,
 	)
-	server := server.New(serviceSession, roomService, authService, config)
+	go collectGuests(authService)
+	server := server.New(serviceSession, roomService, authService, matchService, policy, sessionEvents, roomEvents, ratelimit.NewMemoryStore(), config)
 	err = server.Run(":" + port)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// guestCollectInterval is how often the inactive guests are deleted
+const guestCollectInterval = time.Hour
+
+// collectGuests deletes the inactive guests now and then every guestCollectInterval
+func collectGuests(authService *auth.AuthService) {
+	ticker := time.NewTicker(guestCollectInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := authService.CollectGuests()
+		if err != nil {
+			log.Error(err)
+		} else if deleted > 0 {
+			log.Info("Deleted ", deleted, " inactive guests")
+		}
+		<-ticker.C
+	}
+}
+
+const migrateUsage = "Usage: migrate up|down|status|seed"
+
+// migrate runs the migrate subcommand:
+// up applies pending migrations, down reverts the latest one,
+// status lists them and seed loads the test users in dev
+func migrate(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.InMemory() {
+		log.Info("In-memory storage has nothing to migrate")
+		return nil
+	}
+	sqlDB, err := db.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	migrator, err := db.NewMigrator(sqlDB, cfg.DBDriver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			log.Info(fmt.Sprintf("Applied migration %04d_%s", migration.Version, migration.Name))
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Info("Schema is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			log.Info("No migration to revert")
+		} else {
+			log.Info(fmt.Sprintf("Reverted migration %04d_%s", reverted.Version, reverted.Name))
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	case "seed":
+		if os.Getenv("ENV") != "dev" {
+			return errors.New("Test users are loaded only with ENV=dev")
+		}
+		err = migrator.Seed(fixtures.DevUsers)
+		if err != nil {
+			return err
+		}
+		log.Info("Loaded test users")
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+-- Test users for local development, loaded by "migrate seed" only when ENV=dev
+
+INSERT INTO users (user_id, email, password, nickname)
+VALUES ('6e3f9165-3daf-4fdc-9b52-12e6fdd810c1',
+        'test1@bridge.test', '42f749ade7f9e195bf475f37a44cafcb', 'User1'),
+       ('a1983803-7eb9-477a-a860-9a652adfa30d',
+       'zalizniak@zalizniak', 'ac0ddf9e65d57b6a56b2453386cd5db5', 'zalizniak'),
+       ('be2d3a8e-5f95-4716-a0ef-a814b89dbabc',
+       'test2@bridge.test', '42f749ade7f9e195bf475f37a44cafcb', 'SecondUser'),
+       ('851e1367-610f-412f-a840-4dfe0d9db38d',
+       'test3@bridge.test', '42f749ade7f9e195bf475f37a44cafcb', 'OkUser123'),
+       ('af58fe77-a6bb-4169-a960-3107d7bea057',
+       'test4@bridge.test', '42f749ade7f9e195bf475f37a44cafcb', 'LastUser1')
+ON CONFLICT (user_id) DO NOTHING;
+
+INSERT INTO users (user_id, email, password, nickname, role)
+VALUES ('0b7c3a52-9d41-4c5e-8f0a-6d2e1b9c4a17',
+        'admin@bridge.test', '42f749ade7f9e195bf475f37a44cafcb', 'Admin', 'admin')
+ON CONFLICT (user_id) DO NOTHING;
@@ -0,0 +1,7 @@
+// Package fixtures embeds the data loaded into development databases
+package fixtures
+
+import _ "embed"
+
+//go:embed dev_users.sql
+var DevUsers string
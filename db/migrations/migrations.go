@@ -0,0 +1,10 @@
+// Package migrations embeds the numbered schema migrations of every database driver.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+// under the directory of the driver. Once released a migration is never edited,
+// changes to the schema go into a new one with the next version.
+package migrations
+
+import "embed"
+
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
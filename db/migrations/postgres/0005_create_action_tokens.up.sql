@@ -0,0 +1,12 @@
+-- Links mailed to users to verify the email or to reset the password, each works once
+
+ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT false;
+
+CREATE TABLE action_tokens (
+    token_id text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    purpose text NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX action_tokens_user_id ON action_tokens (user_id, purpose);
@@ -0,0 +1,3 @@
+DROP INDEX users_guest;
+
+ALTER TABLE users DROP COLUMN guest;
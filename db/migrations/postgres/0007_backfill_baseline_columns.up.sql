@@ -0,0 +1,21 @@
+-- 0001 creates tables only if missing, so a database set up before migrations
+-- were versioned keeps its tables as they were, without the columns added
+-- since. Contrary to what 0001 says, it does not adopt the schema as is:
+-- it gets the missing columns here. On a database created by 0001 this
+-- changes nothing.
+
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS winner text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS reshuffles integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS demanded_suit smallint,
+    ADD COLUMN IF NOT EXISTS direction smallint NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS rules jsonb NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS pending jsonb NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS bridge boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 0;
+
+ALTER TABLE players
+    ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 0;
+
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS settings jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS matches;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS players;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
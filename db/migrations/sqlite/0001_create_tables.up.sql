@@ -0,0 +1,58 @@
+-- Arrays are stored as JSON text, SQLite has no array columns
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id text PRIMARY KEY,
+    email text,
+    password text,
+    nickname text,
+    token text,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id text PRIMARY KEY,
+    players    text NOT NULL DEFAULT '[]',
+    deck    text NOT NULL DEFAULT '[]',
+    session_table    text NOT NULL DEFAULT '[]',
+    current_player text REFERENCES users (user_id) ON DELETE CASCADE,
+    winner text NOT NULL DEFAULT '',
+    reshuffles integer NOT NULL DEFAULT 0,
+    demanded_suit smallint,
+    direction smallint NOT NULL DEFAULT 1,
+    rules text NOT NULL DEFAULT '{}',
+    pending text NOT NULL DEFAULT '{}',
+    bridge boolean NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS players (
+    user_id text NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    nickname text,
+    cards    text NOT NULL DEFAULT '[]',
+    state    smallint,
+    state_name    text,
+    session_id   text NOT NULL REFERENCES sessions (session_id) ON DELETE CASCADE,
+    version integer NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, session_id)
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+    room_id text PRIMARY KEY,
+    host_id text REFERENCES users (user_id) ON DELETE CASCADE,
+    user_ids    text NOT NULL DEFAULT '[]',
+    open        boolean,
+    settings    text NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS matches (
+    match_id text PRIMARY KEY,
+    room_id text NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    players    text NOT NULL DEFAULT '[]',
+    score_limit integer NOT NULL,
+    rounds      text NOT NULL DEFAULT '[]',
+    finished    boolean NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
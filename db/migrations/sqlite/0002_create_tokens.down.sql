@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN token text NOT NULL DEFAULT '';
+
+DROP TABLE tokens;
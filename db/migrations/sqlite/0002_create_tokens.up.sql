@@ -0,0 +1,15 @@
+-- Every device a user logs in on gets its own token
+
+CREATE TABLE tokens (
+    token_id text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    hash text NOT NULL UNIQUE,
+    device text NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX tokens_user_id ON tokens (user_id);
+
+ALTER TABLE users DROP COLUMN token;
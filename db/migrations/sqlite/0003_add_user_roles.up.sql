@@ -0,0 +1,3 @@
+-- Admins may manage any room or session, everybody else is a player
+
+ALTER TABLE users ADD COLUMN role text NOT NULL DEFAULT 'player';
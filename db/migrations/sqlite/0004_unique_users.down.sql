@@ -0,0 +1,2 @@
+DROP INDEX users_nickname_unique;
+DROP INDEX users_email_unique;
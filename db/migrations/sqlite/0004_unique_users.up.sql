@@ -0,0 +1,5 @@
+-- Emails and nicknames are unique ignoring case, empty ones are left out.
+-- Duplicates registered before have to be merged or renamed first.
+
+CREATE UNIQUE INDEX users_email_unique ON users (lower(email)) WHERE email <> '';
+CREATE UNIQUE INDEX users_nickname_unique ON users (lower(nickname)) WHERE nickname <> '';
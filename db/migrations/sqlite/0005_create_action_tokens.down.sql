@@ -0,0 +1,3 @@
+DROP TABLE action_tokens;
+
+ALTER TABLE users DROP COLUMN email_verified;
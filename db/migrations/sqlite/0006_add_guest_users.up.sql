@@ -0,0 +1,6 @@
+-- Guests play without email and password until they claim the account,
+-- the ones that stay inactive are deleted
+
+ALTER TABLE users ADD COLUMN guest boolean NOT NULL DEFAULT false;
+
+CREATE INDEX users_guest ON users (user_id) WHERE guest;
@@ -0,0 +1,2 @@
+-- SQLite databases have always been created with every column of 0001,
+-- only the Postgres ones set up before migrations were versioned lack some
@@ -3,15 +3,56 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
 type Config struct {
 	DBHost     string
 	DBUser     string
 	DBPassword string
 	DBName     string
+	// DBDriver is the SQL database to connect to, for sqlite DBName is the path to the file
+	DBDriver string
+	// TokenKey is the secret login tokens are hashed and access tokens signed with
+	TokenKey string
+	// AccessTokenLifetime, if set by ACCESS_TOKEN_LIFETIME, makes logins hand out
+	// signed access tokens of that lifetime along with refresh tokens
+	AccessTokenLifetime time.Duration
+	// LegacyAuth lets clients authenticate with user_id and token in the query or body.
+	// Deprecated in favour of the Authorization header, on unless LEGACY_AUTH=false.
+	LegacyAuth bool
+	// Storage is where the server keeps its data, postgres unless STORAGE says memory
+	Storage string
+	// TrustProxy takes the client IP from the X-Forwarded-For or X-Real-IP header,
+	// set TRUST_PROXY=true only behind a proxy that sets them
+	TrustProxy bool
+	// SMTPHost is the server emails are sent through, without it they are
+	// written into files in MailDir or, if that is empty too, into the log
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+	// AppURL is where the links in emails point to, the client handles /verify and /reset
+	AppURL string
+	// AllowedOrigins are the sites, besides AppURL and the server itself, whose pages
+	// may open WebSockets, set as a comma separated ALLOWED_ORIGINS
+	AllowedOrigins []string
 }
 
 func GetConfig(env string) (Config, error) {
@@ -22,10 +63,66 @@ func GetConfig(env string) (Config, error) {
 		}
 	}
 
+	legacyAuth, err := strconv.ParseBool(getEnv("LEGACY_AUTH", "true"))
+	if err != nil {
+		return Config{}, fmt.Errorf("Couldn't parse LEGACY_AUTH: %w", err)
+	}
+	trustProxy, err := strconv.ParseBool(getEnv("TRUST_PROXY", "false"))
+	if err != nil {
+		return Config{}, fmt.Errorf("Couldn't parse TRUST_PROXY: %w", err)
+	}
+	var accessTokenLifetime time.Duration
+	if value := os.Getenv("ACCESS_TOKEN_LIFETIME"); value != "" {
+		accessTokenLifetime, err = time.ParseDuration(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("Couldn't parse ACCESS_TOKEN_LIFETIME: %w", err)
+		}
+	}
+
 	return Config{
-		DBHost:     os.Getenv("DB_HOST"),
-		DBUser:     os.Getenv("DB_USER"),
-		DBPassword: os.Getenv("DB_PASSWORD"),
-		DBName:     os.Getenv("DB_NAME"),
+		DBHost:              os.Getenv("DB_HOST"),
+		DBUser:              os.Getenv("DB_USER"),
+		DBPassword:          os.Getenv("DB_PASSWORD"),
+		DBName:              os.Getenv("DB_NAME"),
+		DBDriver:            getEnv("DB_DRIVER", DriverPostgres),
+		TokenKey:            os.Getenv("TOKEN_KEY"),
+		AccessTokenLifetime: accessTokenLifetime,
+		LegacyAuth:          legacyAuth,
+		Storage:             getEnv("STORAGE", StoragePostgres),
+		TrustProxy:          trustProxy,
+		SMTPHost:            os.Getenv("SMTP_HOST"),
+		SMTPPort:            getEnv("SMTP_PORT", "587"),
+		SMTPUser:            os.Getenv("SMTP_USER"),
+		SMTPPassword:        os.Getenv("SMTP_PASSWORD"),
+		MailFrom:            getEnv("MAIL_FROM", "Bridge <noreply@bridge.local>"),
+		MailDir:             os.Getenv("MAIL_DIR"),
+		AppURL:              getEnv("APP_URL", "http://localhost:8080"),
+		AllowedOrigins:      splitList(os.Getenv("ALLOWED_ORIGINS")),
 	}, nil
 }
+
+func (c Config) InMemory() bool {
+	return c.Storage == StorageMemory
+}
+
+func (c Config) SQLite() bool {
+	return c.DBDriver == DriverSQLite
+}
+
+// splitList returns the non-empty items of a comma separated list
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
@@ -1,11 +1,15 @@
 package core
 
 import (
+	"time"
+
 	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
 	"github.com/mrbttf/bridge-server/pkg/core/state"
 )
 
 type Card = deck.Card
+type Suit = deck.Suit
 
 func NewCard(suit deck.Suit, rank deck.Rank) deck.Card {
 	return Card{
@@ -14,18 +18,87 @@ func NewCard(suit deck.Suit, rank deck.Rank) deck.Card {
 	}
 }
 
+const (
+	ShortDeckSize = 36
+	FullDeckSize  = 52
+	// MaxEffectSkip is the most players a card may skip, it goes round a full table
+	MaxEffectSkip = 8
+)
+
 func NewDeck() []deck.Card {
+	return NewDeckOfSize(ShortDeckSize)
+}
+
+// NewDeckOfSize returns a shuffled deck of 36 cards from Six to Ace or of full 52 cards
+func NewDeckOfSize(size int) []deck.Card {
+	if size == FullDeckSize {
+		return deck.New(deck.Shuffle)
+	}
 	return deck.New(deck.Filter(func(card deck.Card) bool {
 		return card.Rank < deck.Six && card.Rank != deck.Ace
 	}), deck.Shuffle)
 }
 
+// Role tells what a user may do besides playing
+type Role string
+
+const (
+	RolePlayer Role = "player"
+	// RoleAdmin may manage any room or session
+	RoleAdmin Role = "admin"
+)
+
 type User struct {
 	Id       string
 	Email    string
 	Password string
 	Nickname string
-	Token    string
+	Role     Role
+	// EmailVerified is set once the user has opened a link mailed to the email
+	EmailVerified bool
+	// Guest is set for a user without email and password until it claims the account
+	Guest bool
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Token is a login of a user on one device.
+// Only the hash of the secret is kept, the user gets the secret once at login.
+type Token struct {
+	Id         string
+	UserId     string
+	Hash       string
+	Device     string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
+
+type TokenPurpose string
+
+const (
+	PurposeVerifyEmail   TokenPurpose = "verify_email"
+	PurposeResetPassword TokenPurpose = "reset_password"
+)
+
+// ActionToken lets the holder of a link mailed to a user act for it once,
+// the link carries a signed token naming the stored one
+type ActionToken struct {
+	Id        string
+	UserId    string
+	Purpose   TokenPurpose
+	ExpiresAt time.Time
+}
+
+// Credentials are what a login hands out. Token authenticates requests until
+// ExpiresAt. With signed access tokens it is short-lived and RefreshToken,
+// the secret of the stored Token, gets the next one.
+type Credentials struct {
+	Token        string
+	RefreshToken string
+	ExpiresAt    time.Time
 }
 
 type Player struct {
@@ -34,6 +107,8 @@ type Player struct {
 	Cards     []Card
 	State     state.State
 	SessionId string
+	// Version is the stored version the player was read at
+	Version int
 }
 
 type Session struct {
@@ -42,6 +117,17 @@ type Session struct {
 	Deck          []Card
 	Table         []Card
 	CurrentPlayer string
+	Winner        string
+	Reshuffles    int
+	DemandedSuit  *Suit
+	Direction     int
+	Rules         rules.RuleSet
+	// Pending collects effects of the cards laid during the current turn
+	Pending rules.Effect
+	// Bridge is set when the winner ended the round by declaring a bridge
+	Bridge bool
+	// Version is the stored version the session was read at
+	Version int
 }
 
 func (s Session) HasPlayer(player_id string) bool {
@@ -53,9 +139,141 @@ func (s Session) HasPlayer(player_id string) bool {
 	return false
 }
 
+// NextPlayer returns who takes the turn after the current player
+// following the direction of play
+func (s Session) NextPlayer() string {
+	idx := -1
+	for i, p := range s.Players {
+		if p == s.CurrentPlayer {
+			idx = i
+			break
+		}
+	}
+	n := len(s.Players)
+	idx = ((idx+s.Direction)%n + n) % n
+	return s.Players[idx]
+}
+
+func (s Session) IsFinished() bool {
+	return s.Winner != ""
+}
+
+// BridgeSize is how many cards of the same rank on top of the table make a bridge
+const BridgeSize = 4
+
+// HasBridge tells if the last cards on the table share a rank
+func (s Session) HasBridge() bool {
+	if len(s.Table) < BridgeSize {
+		return false
+	}
+	top := s.Table[len(s.Table)-BridgeSize:]
+	for _, card := range top[1:] {
+		if card.Rank != top[0].Rank {
+			return false
+		}
+	}
+	return true
+}
+
+type SessionEventType string
+
+const (
+	EventCardLaid    SessionEventType = "card_laid"
+	EventCardPulled  SessionEventType = "card_pulled"
+	EventTurnChanged SessionEventType = "turn_changed"
+	EventGameOver    SessionEventType = "game_over"
+)
+
+// SessionEvent tells the players of a session what has just happened.
+// Card is set only for laid cards, pulled cards stay secret.
+type SessionEvent struct {
+	Type      SessionEventType
+	SessionId string
+	PlayerId  string
+	Card      *Card
+}
+
+const (
+	DirectionClockwise        = 1
+	DirectionCounterClockwise = -1
+)
+
+// RoomSettings is the house variant the room plays
+type RoomSettings struct {
+	Rules      rules.RuleSet
+	DeckSize   int
+	DealerHand int
+	PlayerHand int
+}
+
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		Rules:      rules.Default(),
+		DeckSize:   ShortDeckSize,
+		DealerHand: 5,
+		PlayerHand: 4,
+	}
+}
+
 type Room struct {
-	Id    string
-	Host  string
-	Users []string
-	Open  bool
+	Id       string
+	Host     string
+	Users    []string
+	Open     bool
+	Settings RoomSettings
+}
+
+type RoomEventType string
+
+const (
+	EventRoomCreated RoomEventType = "room_created"
+	EventUserJoined  RoomEventType = "user_joined"
+	EventUserLeft    RoomEventType = "user_left"
+	EventHostChanged RoomEventType = "host_changed"
+	EventRoomClosed  RoomEventType = "room_closed"
+	EventRoomDeleted RoomEventType = "room_deleted"
+)
+
+// RoomEvent tells the lobby what has changed in a room.
+// Id is given by the publisher and grows with every event.
+type RoomEvent struct {
+	Id     int64
+	Type   RoomEventType
+	RoomId string
+	UserId string
+}
+
+type Round struct {
+	SessionId string
+	Dealer    string
+	Scores    map[string]int
+}
+
+type Match struct {
+	Id       string
+	RoomId   string
+	Players  []string
+	Limit    int
+	Rounds   []Round
+	Finished bool
+}
+
+func (m Match) CurrentRound() *Round {
+	if len(m.Rounds) == 0 {
+		return nil
+	}
+	return &m.Rounds[len(m.Rounds)-1]
+}
+
+func (m Match) Scores() map[string]int {
+	scores := make(map[string]int, len(m.Players))
+	for _, player_id := range m.Players {
+		scores[player_id] = 0
+	}
+	for _, round := range m.Rounds {
+		for player_id, points := range round.Scores {
+			scores[player_id] += points
+		}
+	}
+	return scores
 }
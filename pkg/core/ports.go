@@ -4,12 +4,48 @@ import (
 	"errors"
 
 	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core/state"
 )
 
 ////go:generate mockgen -source=ports.go  -destination=port_mocks.go -package=core
 
 var (
 	NoRoomForUserError = errors.New("User has no room")
+	// VersionConflictError is returned by Store when the stored version
+	// has moved since the aggregate was read
+	VersionConflictError = errors.New("Stored version has changed")
+	TokenNotFoundError   = errors.New("Token not found")
+	LoginInvalidError    = errors.New("Invalid email or password")
+	// UserExistsError is returned by UserRepository.Store when another user
+	// has the email or the nickname, which are compared ignoring case
+	UserExistsError = errors.New("User already exists")
+	// NotAllowedError is returned by PolicyPort when the user may not do what it asks
+	NotAllowedError = errors.New("User is not allowed to do this")
+	// NotHostError is returned when someone other than the host changes the room
+	NotHostError = errors.New("Only the host can change the room")
+	// NotInRoomError is returned when a user who is not in the room acts in it
+	NotInRoomError = errors.New("User is not in the room")
+	// NotGuestError is returned when claiming an account that is not a guest's
+	NotGuestError = errors.New("User is not a guest")
+	// MailNotSentError is wrapped by the errors of what failed only because
+	// an email could not be sent
+	MailNotSentError = errors.New("Email could not be sent")
+)
+
+// Errors of moves the rules of the game do not allow, the services return them
+// wrapped so that clients can be told what went wrong
+var (
+	IllegalMoveError             = state.IllegalMoveError
+	CardNotFoundError            = errors.New("Card not found")
+	PlayerInSessionNotFoundError = errors.New("Player not found in session")
+	SessionFinishedError         = errors.New("Session is finished")
+	DeckExhaustedError           = errors.New("No cards left in deck and on table")
+	SuitDemandError              = errors.New("Only a Jack can demand a suit")
+	NotPlayersTurnError          = errors.New("It is not the player's turn")
+	NotEnoughCardsError          = errors.New("Not enough cards in deck to deal")
+	NoBridgeError                = errors.New("There is no bridge to declare")
+	MatchFinishedError           = errors.New("Match is finished")
+	RoundNotFinishedError        = errors.New("Round is not finished yet")
 )
 
 type SessionRepository interface {
@@ -25,9 +61,15 @@ type PlayerRepository interface {
 
 type UserRepository interface {
 	Get(string) (User, error)
+	// GetByEmail and GetByNickname ignore case
 	GetByEmail(string) (User, error)
+	GetByNickname(string) (User, error)
 	GetForRoom(string) ([]User, error)
+	ListGuests() ([]User, error)
 	Store(*User) error
+	// Delete removes the user with its tokens, players, hosted rooms
+	// and the sessions waiting for it to move
+	Delete(string) error
 }
 
 type RoomRepository interface {
@@ -38,21 +80,132 @@ type RoomRepository interface {
 	Delete(string) error
 }
 
+type MatchRepository interface {
+	Get(string) (Match, error)
+	Store(*Match) error
+}
+
+type TokenRepository interface {
+	GetByHash(string) (Token, error)
+	// ListForUser returns the tokens of a user, oldest first
+	ListForUser(string) ([]Token, error)
+	Store(*Token) error
+	Delete(string) error
+	DeleteForUser(string) error
+}
+
+type ActionTokenRepository interface {
+	Store(*ActionToken) error
+	// Consume deletes the token and returns it, it fails if the token is not there,
+	// so of concurrent calls for the same token only one gets it
+	Consume(string) (ActionToken, error)
+	DeleteForUser(user_id string, purpose TokenPurpose) error
+}
+
+// Repositories gives access to every aggregate from within one unit of work
+type Repositories struct {
+	Sessions SessionRepository
+	Players  PlayerRepository
+	Users    UserRepository
+	Rooms    RoomRepository
+	Matches  MatchRepository
+	Tokens   TokenRepository
+	// ActionTokens are the single-use tokens of the links mailed to users
+	ActionTokens ActionTokenRepository
+	// AfterCommit holds fn back until the unit of work has committed and drops it
+	// if it does not, it is nil for repositories outside a unit of work
+	AfterCommit func(fn func())
+}
+
+// UnitOfWork runs fn with repositories that share one transaction:
+// what fn stores is committed if it returns nil and discarded otherwise
+type UnitOfWork interface {
+	Do(fn func(Repositories) error) error
+}
+
+// Committed collects what a unit of work runs once it has committed,
+// such as telling others about what it stored
+type Committed struct {
+	fns []func()
+}
+
+func (c *Committed) Add(fn func()) {
+	c.fns = append(c.fns, fn)
+}
+
+func (c *Committed) Run() {
+	for _, fn := range c.fns {
+		fn()
+	}
+}
+
+// Mailer sends a plain text email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+type SessionEventPublisher interface {
+	Publish(session_id string, event SessionEvent)
+}
+
+// SessionEventSubscriber streams the events of a session until the returned cancel func is called
+type SessionEventSubscriber interface {
+	Subscribe(session_id string) (<-chan SessionEvent, func())
+}
+
+type RoomEventPublisher interface {
+	Publish(event RoomEvent)
+}
+
+// RoomEventSubscriber streams room events, starting with the ones
+// published after last_event_id that are still remembered
+type RoomEventSubscriber interface {
+	Subscribe(last_event_id int64) (<-chan RoomEvent, func())
+}
+
 type SessionServicePort interface {
 	GetSession(string) (Session, error)
 	GetPlayer(string) (Player, error)
-	Create(string, []deck.Card) (string, error)
+	Create(string, string, []deck.Card) (string, error)
+	// CreateForPlayers is Create dealing only to the given users of the room
+	CreateForPlayers(room_id string, player_ids []string, dealer_id string, _deck []deck.Card) (string, error)
 	Pull(string, string) error
-	Lay(string, string, Card) error
+	Lay(string, string, Card, *Suit) error
+	Bridge(string, string, bool) error
 	NextTurn(string, string) error
 	DeleteSession(string) error
+	// With returns the service working inside the unit of work the repositories belong to
+	With(Repositories) SessionServicePort
 }
 
 type AuthServicePort interface {
-	Login(email, password string) (User, error)
+	// Login returns the user and the credentials of a new token for device
+	Login(email, password, device string) (User, Credentials, error)
+	// Register stores the user and mails it a link to verify the email
 	Register(email, password, nickname string) error
-	Logout(email, token string) error
-	ValidateToken(user_id, token string) error
+	// LoginGuest creates a guest with a generated nickname and logs it in on device
+	LoginGuest(device string) (User, Credentials, error)
+	// Claim turns a guest into a registered user, keeping its id and what belongs to it
+	Claim(user_id, email, password, nickname string) error
+	// CollectGuests deletes the guests that have been inactive too long and returns how many
+	CollectGuests() (int, error)
+	// Refresh replaces the refresh token with a new one and issues the next access token
+	Refresh(refresh_token string) (Credentials, error)
+	Revoke(user_id, token_id string) error
+	RevokeAll(user_id string) error
+	// Authenticate returns the token with the secret and extends it
+	Authenticate(token string) (Token, error)
+	// ValidateToken is Authenticate that also checks the token belongs to user_id
+	ValidateToken(user_id, token string) (Token, error)
+	Tokens(user_id string) ([]Token, error)
+	// SendVerification mails the user a link to verify its email
+	SendVerification(user_id string) error
+	VerifyEmail(token string) error
+	// ForgotPassword mails a link to reset the password if a user has the email,
+	// what it returns must not reach the client
+	ForgotPassword(email string) error
+	// ResetPassword sets the password and logs the user out everywhere
+	ResetPassword(token, password string) error
 }
 
 type RoomServicePort interface {
@@ -60,7 +213,26 @@ type RoomServicePort interface {
 	Get(room_id string) (Room, error)
 	GetUsers(room_id string) ([]User, error)
 	Join(room_id, user_id string) error
+	Leave(room_id, user_id string) error
 	List(open bool) ([]Room, error)
 	Close(room_id string) error
+	UpdateSettings(room_id, user_id string, settings RoomSettings) error
 	Delete(room_id string) error
+	// With returns the service working inside the unit of work the repositories belong to
+	With(Repositories) RoomServicePort
+}
+
+// PolicyPort checks the user may manage a room, a session or a match,
+// errors wrap NotAllowedError if it may not
+type PolicyPort interface {
+	CanDeleteRoom(user_id, room_id string) error
+	CanCreateSession(user_id, room_id string) error
+	CanCloseSession(user_id, session_id string) error
+	CanPlayMatch(user_id, match_id string) error
+}
+
+type MatchServicePort interface {
+	Create(room_id string, limit int) (string, error)
+	Get(match_id string) (Match, error)
+	NextRound(match_id string) error
 }
@@ -0,0 +1,81 @@
+package rules
+
+import (
+	"github.com/MrBTTF/gophercises/deck"
+	"golang.org/x/exp/slices"
+)
+
+// Effect is what laying a card does to the players after the one who laid it.
+type Effect struct {
+	Pull    int  // cards the next player has to pull
+	Skip    int  // players who lose their turn
+	Reverse bool // direction of play changes
+}
+
+// Add combines effects of several cards laid during one turn
+func (e Effect) Add(other Effect) Effect {
+	return Effect{
+		Pull:    e.Pull + other.Pull,
+		Skip:    e.Skip + other.Skip,
+		Reverse: e.Reverse != other.Reverse,
+	}
+}
+
+func (e Effect) IsNone() bool {
+	return e == Effect{}
+}
+
+type Rules interface {
+	// MustLay tells if the player who laid the card has to cover it before ending the turn
+	MustLay(card deck.Card) bool
+	// MustLayOrPull tells if the player who laid the card has to lay again or pull
+	MustLayOrPull(card deck.Card) bool
+	// CanStack tells if the card may be laid on top of the same rank
+	CanStack(card deck.Card) bool
+	// Effect tells what the card does to the next players once the turn ends
+	Effect(card deck.Card) Effect
+}
+
+// RuleSet declares the special ranks of a game variant
+type RuleSet struct {
+	MustLayRanks       []deck.Rank
+	MustLayOrPullRanks []deck.Rank
+	// StackRanks may be laid on a card of the same rank to add up their effects
+	StackRanks []deck.Rank
+	Effects    map[deck.Rank]Effect
+}
+
+// Default is the variant played unless a room says otherwise:
+// Six has to be covered, Seven makes the next player pull one,
+// Eight makes them pull two and skip, Ace skips and Queen reverses the direction.
+func Default() RuleSet {
+	return RuleSet{
+		MustLayRanks:       []deck.Rank{deck.Six},
+		MustLayOrPullRanks: []deck.Rank{deck.Eight, deck.Ace},
+		StackRanks:         []deck.Rank{deck.Seven, deck.Eight, deck.Ace, deck.Queen},
+		Effects: map[deck.Rank]Effect{
+			deck.Seven: {Pull: 1},
+			deck.Eight: {Pull: 2, Skip: 1},
+			deck.Ace:   {Skip: 1},
+			deck.Queen: {Reverse: true},
+		},
+	}
+}
+
+func (rs RuleSet) MustLay(card deck.Card) bool {
+	return slices.Contains(rs.MustLayRanks, card.Rank)
+}
+
+func (rs RuleSet) MustLayOrPull(card deck.Card) bool {
+	return slices.Contains(rs.MustLayOrPullRanks, card.Rank)
+}
+
+// CanStack tells if the card may be laid on top of the same rank.
+// Ranks without an effect can always be.
+func (rs RuleSet) CanStack(card deck.Card) bool {
+	return rs.Effect(card).IsNone() || slices.Contains(rs.StackRanks, card.Rank)
+}
+
+func (rs RuleSet) Effect(card deck.Card) Effect {
+	return rs.Effects[card.Rank]
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// accessClaims is what a signed access token says, it is checked by
+// the signature alone, so it stays valid until it expires even if the
+// token it was issued for is revoked
+type accessClaims struct {
+	UserId    string `json:"sub"`
+	TokenId   string `json:"sid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// accessKey derives the signing key from the token key,
+// so signatures and stored token hashes never share a key
+func accessKey(tokenKey []byte) []byte {
+	return deriveKey(tokenKey, "access token")
+}
+
+func deriveKey(tokenKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, tokenKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func signAccessToken(key []byte, claims accessClaims) string {
+	return signClaims(key, claims)
+}
+
+func verifyAccessToken(key []byte, token string, now time.Time) (accessClaims, error) {
+	var claims accessClaims
+	err := verifyClaims(key, token, &claims)
+	if err != nil || claims.UserId == "" {
+		return accessClaims{}, TokenInvalidError
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return accessClaims{}, TokenExpiredError
+	}
+	return claims, nil
+}
+
+// signClaims encodes claims as <payload>.<signature>,
+// both base64url encoded, the payload being JSON
+func signClaims(key []byte, claims any) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(key, encoded))
+}
+
+// verifyClaims checks the signature of token and decodes its payload into claims
+func verifyClaims(key []byte, token string, claims any) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return TokenInvalidError
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, sign(key, encoded)) {
+		return TokenInvalidError
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return TokenInvalidError
+	}
+	if json.Unmarshal(payload, claims) != nil {
+		return TokenInvalidError
+	}
+	return nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+const (
+	VerifyEmailLifetime   = 48 * time.Hour
+	ResetPasswordLifetime = time.Hour
+)
+
+// actionClaims is what the token of a mailed link says, the token is also
+// stored under TokenId, so that the link works once
+type actionClaims struct {
+	UserId    string            `json:"sub"`
+	Purpose   core.TokenPurpose `json:"purpose"`
+	TokenId   string            `json:"jti"`
+	ExpiresAt int64             `json:"exp"`
+}
+
+// SendVerification mails the user a link to verify its email,
+// the links sent before stop working
+func (as *AuthService) SendVerification(user_id string) error {
+	user, err := as.user.Get(user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to send verification for user_id: %s: %w", user_id, err)
+	}
+	if user.Guest {
+		// a guest has no email until it claims the account
+		return fmt.Errorf("Unable to send verification for user_id: %s: %w", user_id, core.NotAllowedError)
+	}
+	err = as.sendVerification(user)
+	if err != nil {
+		return fmt.Errorf("Unable to send verification for user_id: %s: %w", user_id, err)
+	}
+	return nil
+}
+
+func (as *AuthService) sendVerification(user core.User) error {
+	token, err := as.issueActionToken(user.Id, core.PurposeVerifyEmail, VerifyEmailLifetime)
+	if err != nil {
+		return err
+	}
+	return as.mail(user.Email, "Verify your email",
+		"Hi "+user.Nickname+",\n\n"+
+			"open this link to verify your email:\n"+
+			as.link("verify", token)+"\n\n"+
+			"It works for "+VerifyEmailLifetime.String()+".\n")
+}
+
+// VerifyEmail marks the email of the user the token was mailed to as verified
+func (as *AuthService) VerifyEmail(token string) error {
+	user, err := as.consumeActionToken(token, core.PurposeVerifyEmail)
+	if err != nil {
+		return fmt.Errorf("Unable to verify email: %w", err)
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	user.EmailVerified = true
+	err = as.user.Store(&user)
+	if err != nil {
+		return fmt.Errorf("Unable to verify email: %w", err)
+	}
+	return nil
+}
+
+// ForgotPassword mails a link to reset the password to the user with the email.
+// It does nothing for an unknown email. Its errors are for the log only, telling
+// the client about them or waiting for the mail would tell who is registered.
+func (as *AuthService) ForgotPassword(email string) error {
+	user, err := as.user.GetByEmail(strings.TrimSpace(email))
+	if err != nil || user.Guest {
+		return nil
+	}
+	token, err := as.issueActionToken(user.Id, core.PurposeResetPassword, ResetPasswordLifetime)
+	if err != nil {
+		return fmt.Errorf("Unable to send password reset for email: %s: %w", email, err)
+	}
+	err = as.mail(user.Email, "Reset your password",
+		"Hi "+user.Nickname+",\n\n"+
+			"open this link to choose a new password:\n"+
+			as.link("reset", token)+"\n\n"+
+			"It works for "+ResetPasswordLifetime.String()+". If you have not asked for it, ignore this email.\n")
+	if err != nil {
+		return fmt.Errorf("Unable to send password reset for email: %s: %w", email, err)
+	}
+	return nil
+}
+
+// ResetPassword sets the password of the user the token was mailed to
+// and logs it out on every device. An invalid password is reported as a
+// *core.ValidationError and leaves the token usable.
+func (as *AuthService) ResetPassword(token, password string) error {
+	claims, err := as.verifyActionToken(token, core.PurposeResetPassword)
+	if err != nil {
+		return fmt.Errorf("Unable to reset password: %w", err)
+	}
+	user, err := as.user.Get(claims.UserId)
+	if err != nil {
+		return fmt.Errorf("Unable to reset password: %w", TokenInvalidError)
+	}
+	invalid := &core.ValidationError{}
+	validatePassword(invalid, password, user.Email, user.Nickname)
+	if err := invalid.Err(); err != nil {
+		return fmt.Errorf("Unable to reset password: %w", err)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("Unable to reset password: %w", err)
+	}
+	err = as.uow.Do(func(repos core.Repositories) error {
+		return as.with(repos).resetPassword(token, hash)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to reset password: %w", err)
+	}
+	return nil
+}
+
+// resetPassword uses the token up, stores the hashed password and revokes the tokens
+// of the user, which must all happen or none, lest the link works again or the old
+// logins survive the reset
+func (as *AuthService) resetPassword(token, hash string) error {
+	user, err := as.consumeActionToken(token, core.PurposeResetPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	// the link reached the mailbox, which is as good as verifying it
+	user.EmailVerified = true
+	err = as.user.Store(&user)
+	if err != nil {
+		return err
+	}
+	return as.RevokeAll(user.Id)
+}
+
+// issueActionToken stores and signs a token for purpose, replacing the ones
+// the user already has for it
+func (as *AuthService) issueActionToken(user_id string, purpose core.TokenPurpose, lifetime time.Duration) (string, error) {
+	err := as.actions.DeleteForUser(user_id, purpose)
+	if err != nil {
+		return "", err
+	}
+	token := core.ActionToken{
+		Id:        uuid.New().String(),
+		UserId:    user_id,
+		Purpose:   purpose,
+		ExpiresAt: as.now().UTC().Add(lifetime).Truncate(time.Second),
+	}
+	err = as.actions.Store(&token)
+	if err != nil {
+		return "", err
+	}
+	return signClaims(as.actionKey, actionClaims{
+		UserId:    user_id,
+		Purpose:   purpose,
+		TokenId:   token.Id,
+		ExpiresAt: token.ExpiresAt.Unix(),
+	}), nil
+}
+
+func (as *AuthService) verifyActionToken(token string, purpose core.TokenPurpose) (actionClaims, error) {
+	var claims actionClaims
+	err := verifyClaims(as.actionKey, token, &claims)
+	if err != nil || claims.Purpose != purpose || claims.TokenId == "" {
+		return actionClaims{}, TokenInvalidError
+	}
+	if !as.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return actionClaims{}, TokenExpiredError
+	}
+	return claims, nil
+}
+
+// consumeActionToken checks the token and deletes it, so that it is used once,
+// and returns the user it was issued to
+func (as *AuthService) consumeActionToken(token string, purpose core.TokenPurpose) (core.User, error) {
+	claims, err := as.verifyActionToken(token, purpose)
+	if err != nil {
+		return core.User{}, err
+	}
+	stored, err := as.actions.Consume(claims.TokenId)
+	if err != nil || stored.UserId != claims.UserId || stored.Purpose != purpose {
+		return core.User{}, TokenInvalidError
+	}
+	user, err := as.user.Get(stored.UserId)
+	if err != nil {
+		return core.User{}, TokenInvalidError
+	}
+	return user, nil
+}
+
+func (as *AuthService) mail(to, subject, body string) error {
+	err := as.mailer.Send(to, subject, body)
+	if err != nil {
+		return errors.Join(core.MailNotSentError, err)
+	}
+	return nil
+}
+
+// link is the address of page on the site, the client takes the token from it
+func (as *AuthService) link(page, token string) string {
+	return strings.TrimSuffix(as.linkURL, "/") + "/" + page + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMail struct {
+	to, subject, body string
+}
+
+// testMailer keeps the emails it is asked to send, or fails with err
+type testMailer struct {
+	sent []testMail
+	err  error
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, testMail{to, subject, body})
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`https://bridge\.test/(\w+)\?token=(\S+)`)
+
+// lastLink returns the page and the token of the link in the last email
+func (m *testMailer) lastLink() (string, string) {
+	if len(m.sent) == 0 {
+		return "", ""
+	}
+	match := linkPattern.FindStringSubmatch(m.sent[len(m.sent)-1].body)
+	if match == nil {
+		return "", ""
+	}
+	token, err := url.QueryUnescape(match[2])
+	if err != nil {
+		panic(err)
+	}
+	return match[1], token
+}
+
+// failingRevoke makes deleting the tokens of a user inside the unit of work fail
+type failingRevoke struct {
+	uow core.UnitOfWork
+	err error
+}
+
+func (f failingRevoke) Do(fn func(core.Repositories) error) error {
+	return f.uow.Do(func(repos core.Repositories) error {
+		repos.Tokens = failingTokenRepository{TokenRepository: repos.Tokens, err: f.err}
+		return fn(repos)
+	})
+}
+
+type failingTokenRepository struct {
+	core.TokenRepository
+	err error
+}
+
+func (f failingTokenRepository) DeleteForUser(string) error {
+	return f.err
+}
+
+func newMailService() (*AuthService, *testMailer, *time.Time) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	mailer := &testMailer{}
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, mailer, testTokenKey, 0, testLinkURL)
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	auth_service.now = func() time.Time { return now }
+	err := auth_service.Register("user@bridge.test", testPassword, "User")
+	if err != nil {
+		panic(err)
+	}
+	return auth_service, mailer, &now
+}
+
+func TestAuthVerifyEmail(t *testing.T) {
+	auth_service, mailer, now := newMailService()
+	user, err := auth_service.user.GetByEmail("user@bridge.test")
+	if err != nil {
+		panic(err)
+	}
+	assert.False(t, user.EmailVerified)
+	assert.Len(t, mailer.sent, 1, "registering mails the verification link")
+	assert.Equal(t, "user@bridge.test", mailer.sent[0].to)
+	page, first := mailer.lastLink()
+	assert.Equal(t, "verify", page)
+
+	err = auth_service.SendVerification(user.Id)
+	assert.NoError(t, err)
+	_, token := mailer.lastLink()
+	err = auth_service.VerifyEmail(first)
+	assert.ErrorIs(t, err, TokenInvalidError, "a new link replaces the old one")
+
+	err = auth_service.ResetPassword(token, "another password")
+	assert.ErrorIs(t, err, TokenInvalidError, "a verification token does not reset passwords")
+	err = auth_service.VerifyEmail("x" + token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+
+	*now = now.Add(VerifyEmailLifetime)
+	err = auth_service.VerifyEmail(token)
+	assert.ErrorIs(t, err, TokenExpiredError)
+	*now = now.Add(-time.Minute)
+	err = auth_service.VerifyEmail(token)
+	assert.NoError(t, err)
+	user, err = auth_service.user.Get(user.Id)
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, user.EmailVerified)
+
+	err = auth_service.VerifyEmail(token)
+	assert.ErrorIs(t, err, TokenInvalidError, "the token works once")
+}
+
+func TestAuthRegisterMailFails(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	mailer := &testMailer{err: errors.New("connection refused")}
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, mailer, testTokenKey, 0, testLinkURL)
+
+	err := auth_service.Register("user@bridge.test", testPassword, "User")
+	assert.ErrorIs(t, err, core.MailNotSentError)
+	_, err = repos.Users.GetByEmail("user@bridge.test")
+	assert.NoError(t, err, "the user is registered without the email")
+}
+
+func TestAuthResetPassword(t *testing.T) {
+	auth_service, mailer, now := newMailService()
+	_, _, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	if err != nil {
+		panic(err)
+	}
+	mailer.sent = nil
+
+	err = auth_service.ForgotPassword("nobody@bridge.test")
+	assert.NoError(t, err, "an unknown email is not told apart")
+	assert.Empty(t, mailer.sent)
+
+	err = auth_service.ForgotPassword(" user@bridge.test ")
+	assert.NoError(t, err)
+	page, token := mailer.lastLink()
+	assert.Equal(t, "reset", page)
+
+	err = auth_service.VerifyEmail(token)
+	assert.ErrorIs(t, err, TokenInvalidError, "a reset token does not verify emails")
+	var invalid *core.ValidationError
+	err = auth_service.ResetPassword(token, "short")
+	assert.ErrorAs(t, err, &invalid)
+	assert.Contains(t, invalid.Fields, "password")
+
+	*now = now.Add(ResetPasswordLifetime)
+	err = auth_service.ResetPassword(token, "another password")
+	assert.ErrorIs(t, err, TokenExpiredError)
+	*now = now.Add(-time.Minute)
+	err = auth_service.ResetPassword(token, "another password")
+	assert.NoError(t, err)
+	err = auth_service.ResetPassword(token, "third password")
+	assert.ErrorIs(t, err, TokenInvalidError, "the token works once")
+
+	user, _, err := auth_service.Login("user@bridge.test", "another password", "laptop")
+	assert.NoError(t, err)
+	assert.True(t, user.EmailVerified, "the reset link proves the email")
+	_, _, err = auth_service.Login("user@bridge.test", testPassword, "laptop")
+	assert.ErrorIs(t, err, LoginInvalidError)
+	tokens, err := auth_service.Tokens(user.Id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Len(t, tokens, 1, "resetting logs out every device")
+}
+
+func TestAuthResetPasswordIsAtomic(t *testing.T) {
+	auth_service, mailer, _ := newMailService()
+	err := auth_service.ForgotPassword("user@bridge.test")
+	if err != nil {
+		panic(err)
+	}
+	_, token := mailer.lastLink()
+
+	uow := auth_service.uow
+	revokeErr := errors.New("Delete failed")
+	auth_service.uow = failingRevoke{uow: uow, err: revokeErr}
+	err = auth_service.ResetPassword(token, "another password")
+	assert.ErrorIs(t, err, revokeErr)
+	_, _, err = auth_service.Login("user@bridge.test", testPassword, "phone")
+	assert.NoError(t, err, "the password is kept if the logins cannot be revoked")
+
+	auth_service.uow = uow
+	err = auth_service.ResetPassword(token, "another password")
+	assert.NoError(t, err, "the token is kept too")
+}
@@ -1,105 +1,381 @@
 package auth
 
 import (
-	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
 )
 
 const tokenLength = 16
 
+const (
+	// TokenLifetime is how long a token stays valid since it was last used
+	TokenLifetime = 30 * 24 * time.Hour
+	// touchInterval limits how often using a token writes it back
+	touchInterval = time.Minute
+)
+
 var (
-	LoginInvalidError = errors.New("Invalid email or password")
-	TokenInvalidError = errors.New("Invalid token")
+	LoginInvalidError    = core.LoginInvalidError
+	TokenInvalidError    = errors.New("Invalid token")
+	TokenExpiredError    = errors.New("Token has expired")
+	RefreshDisabledError = errors.New("Refresh tokens are not issued")
 )
 
 type AuthService struct {
-	user core.UserRepository
+	// uow runs what must be stored together, the repositories below work on their own
+	uow      core.UnitOfWork
+	user     core.UserRepository
+	tokens   core.TokenRepository
+	actions  core.ActionTokenRepository
+	mailer   core.Mailer
+	tokenKey []byte
+	// accessKey signs access tokens, which are issued if accessLifetime is not zero
+	accessKey      []byte
+	accessLifetime time.Duration
+	// actionKey signs the tokens of the links mailed to users, which open pages under linkURL
+	actionKey []byte
+	linkURL   string
+	now       func() time.Time
 }
 
-func New(user core.UserRepository) *AuthService {
+// New returns the service, tokenKey is the secret stored tokens are hashed with.
+// Unless accessLifetime is zero, logins get signed access tokens of that lifetime,
+// which are checked without the database, and the stored token becomes their refresh token.
+// Links to verify emails and reset passwords are mailed with mailer and point to linkURL.
+func New(
+	uow core.UnitOfWork,
+	user core.UserRepository,
+	tokens core.TokenRepository,
+	actions core.ActionTokenRepository,
+	mailer core.Mailer,
+	tokenKey []byte,
+	accessLifetime time.Duration,
+	linkURL string,
+) *AuthService {
 	return &AuthService{
-		user: user,
+		uow:            uow,
+		user:           user,
+		tokens:         tokens,
+		actions:        actions,
+		mailer:         mailer,
+		tokenKey:       tokenKey,
+		accessKey:      accessKey(tokenKey),
+		accessLifetime: accessLifetime,
+		actionKey:      deriveKey(tokenKey, "action token"),
+		linkURL:        linkURL,
+		now:            time.Now,
 	}
 }
 
-func (as *AuthService) Login(email string, password string) (core.User, error) {
-	user, err := as.fetchUser(email, password)
+// with returns the service working inside the unit of work the repositories belong to
+func (as *AuthService) with(repos core.Repositories) *AuthService {
+	bound := *as
+	bound.user = repos.Users
+	bound.tokens = repos.Tokens
+	bound.actions = repos.ActionTokens
+	return &bound
+}
+
+// Login checks the password and creates a token for device,
+// other devices of the user stay logged in
+func (as *AuthService) Login(email, password, device string) (core.User, core.Credentials, error) {
+	user, rehashed, err := as.fetchUser(email, password)
 	if err != nil {
-		return core.User{}, fmt.Errorf("Unable to login: %w", err)
+		return core.User{}, core.Credentials{}, fmt.Errorf("Unable to login: %w", err)
 	}
-	token := generateSecureToken(tokenLength)
-	user.Token = getHash(token)
-	err = as.user.Store(&user)
+	if rehashed {
+		err = as.user.Store(&user)
+		if err != nil {
+			return core.User{}, core.Credentials{}, fmt.Errorf("Unable to login: %w", err)
+		}
+	}
+
+	credentials, err := as.issueToken(user.Id, device)
+	if err != nil {
+		return core.User{}, core.Credentials{}, fmt.Errorf("Unable to login: %w", err)
+	}
+	return user, credentials, nil
+}
+
+// issueToken stores a new token of the user for device and returns its credentials
+func (as *AuthService) issueToken(user_id, device string) (core.Credentials, error) {
+	secret := generateSecureToken(tokenLength)
+	now := as.now().UTC()
+	token := core.Token{
+		Id:         uuid.New().String(),
+		UserId:     user_id,
+		Hash:       hashToken(as.tokenKey, secret),
+		Device:     device,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(TokenLifetime),
+	}
+	err := as.tokens.Store(&token)
+	if err != nil {
+		return core.Credentials{}, err
+	}
+	return as.credentials(token, secret), nil
+}
+
+// Refresh rotates the refresh token: the one passed in stops working
+// and the returned credentials carry its replacement
+func (as *AuthService) Refresh(refresh_token string) (core.Credentials, error) {
+	if as.accessLifetime == 0 {
+		return core.Credentials{}, fmt.Errorf("Unable to refresh: %w", RefreshDisabledError)
+	}
+	token, err := as.findToken(refresh_token)
+	if err != nil {
+		return core.Credentials{}, fmt.Errorf("Unable to refresh: %w", err)
+	}
+
+	secret := generateSecureToken(tokenLength)
+	now := as.now().UTC()
+	token.Hash = hashToken(as.tokenKey, secret)
+	token.LastUsedAt = now
+	token.ExpiresAt = now.Add(TokenLifetime)
+	err = as.tokens.Store(&token)
 	if err != nil {
-		return core.User{}, fmt.Errorf("Unable to login: %w", err)
+		return core.Credentials{}, fmt.Errorf("Unable to refresh: %w", err)
+	}
+	return as.credentials(token, secret), nil
+}
+
+// credentials hands out the secret of token, as it is or,
+// with access tokens, as the refresh token of a new access token
+func (as *AuthService) credentials(token core.Token, secret string) core.Credentials {
+	if as.accessLifetime == 0 {
+		return core.Credentials{
+			Token:     secret,
+			ExpiresAt: token.ExpiresAt,
+		}
+	}
+	expiresAt := as.now().UTC().Add(as.accessLifetime).Truncate(time.Second)
+	return core.Credentials{
+		Token: signAccessToken(as.accessKey, accessClaims{
+			UserId:    token.UserId,
+			TokenId:   token.Id,
+			ExpiresAt: expiresAt.Unix(),
+		}),
+		RefreshToken: secret,
+		ExpiresAt:    expiresAt,
 	}
-	user.Token = token
-	return user, nil
 }
 
+// Register stores a new user if the fields are valid and the email and
+// the nickname are free, otherwise the error wraps a *core.ValidationError.
+// It mails the user a link to verify the email, if that fails the user
+// is registered anyway and the error wraps core.MailNotSentError.
 func (as *AuthService) Register(email string, password string, nickname string) error {
-	passwordHash := getHash(password)
 	user := core.User{
-		Id:       uuid.New().String(),
-		Email:    email,
-		Password: passwordHash,
-		Nickname: nickname,
+		Id:   uuid.New().String(),
+		Role: core.RolePlayer,
 	}
-	err := as.user.Store(&user)
+	err := as.register(user, email, password, nickname)
 	if err != nil {
 		return fmt.Errorf("Unable to register: %w", err)
 	}
 	return nil
 }
 
-func (as *AuthService) Logout(email, token string) error {
-	user, err := as.user.GetByEmail(email)
-	if err != nil {
-		return fmt.Errorf("Unable to logout for email: %s: %w", email, err)
+// register gives user the email, the password and the nickname, checked as
+// for Register, stores it and mails it the link to verify the email
+func (as *AuthService) register(user core.User, email, password, nickname string) error {
+	email = strings.TrimSpace(email)
+	nickname = strings.TrimSpace(nickname)
+	invalid := validateRegistration(email, password, nickname)
+	as.checkTaken(invalid, user.Id, email, nickname)
+	if err := invalid.Err(); err != nil {
+		return err
 	}
-	if user.Token != getHash(token) {
-		return fmt.Errorf("Unable to logout for email: %s: %w", email, TokenInvalidError)
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
 	}
-	user.Token = ""
+	user.Email = email
+	user.Password = passwordHash
+	user.Nickname = nickname
+	user.EmailVerified = false
+	user.Guest = false
 	err = as.user.Store(&user)
+	if errors.Is(err, core.UserExistsError) {
+		// someone has registered the same email or nickname since the check
+		as.checkTaken(invalid, user.Id, email, nickname)
+		if taken := invalid.Err(); taken != nil {
+			err = taken
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to logout for email: %s: %w", email, err)
+		return err
+	}
+	err = as.sendVerification(user)
+	if err != nil {
+		return fmt.Errorf("Unable to send verification for user_id: %s: %w", user.Id, err)
 	}
 	return nil
 }
 
-func (as *AuthService) ValidateToken(user_id, token string) error {
-	user, err := as.user.Get(user_id)
+// checkTaken adds the email or the nickname to invalid if it belongs to a user other than user_id
+func (as *AuthService) checkTaken(invalid *core.ValidationError, user_id, email, nickname string) {
+	if user, err := as.user.GetByEmail(email); err == nil && user.Id != user_id {
+		invalid.Add("email", "is already registered")
+	}
+	if user, err := as.user.GetByNickname(nickname); err == nil && user.Id != user_id {
+		invalid.Add("nickname", "is taken")
+	}
+}
+
+// Revoke logs the user out on the device of token_id.
+// Access tokens already issued for it keep working until they expire.
+func (as *AuthService) Revoke(user_id, token_id string) error {
+	tokens, err := as.tokens.ListForUser(user_id)
 	if err != nil {
-		return fmt.Errorf("Unable to validate token for user_id: %s: %w", user_id, err)
+		return fmt.Errorf("Unable to revoke token %s for user_id: %s: %w", token_id, user_id, err)
+	}
+	if !slices.ContainsFunc(tokens, func(token core.Token) bool { return token.Id == token_id }) {
+		return fmt.Errorf("Unable to revoke token %s for user_id: %s: %w", token_id, user_id, core.TokenNotFoundError)
 	}
-	if user.Token != getHash(token) {
-		return fmt.Errorf("Unable to validate token for user_id: %s: %w", user_id, TokenInvalidError)
+	err = as.tokens.Delete(token_id)
+	if err != nil {
+		return fmt.Errorf("Unable to revoke token %s for user_id: %s: %w", token_id, user_id, err)
 	}
 	return nil
 }
 
-func (as *AuthService) fetchUser(email, password string) (core.User, error) {
-	user, err := as.user.GetByEmail(email)
+// RevokeAll logs the user out on every device
+func (as *AuthService) RevokeAll(user_id string) error {
+	err := as.tokens.DeleteForUser(user_id)
 	if err != nil {
-		return core.User{}, fmt.Errorf("Unable to fetch user for email: %s: %w", email, err)
+		return fmt.Errorf("Unable to revoke tokens for user_id: %s: %w", user_id, err)
 	}
-	passwordHash := getHash(password)
-	if user.Password != passwordHash {
-		return core.User{}, fmt.Errorf("Unable to fetch user for email: %s: %w", email, LoginInvalidError)
+	return nil
+}
+
+// Authenticate returns the token with the secret, which tells the user,
+// and moves its expiry forward: a token in use does not expire.
+// An access token is checked by its signature and only gives the user and token ids.
+func (as *AuthService) Authenticate(token string) (core.Token, error) {
+	found, err := as.authenticate(token)
+	if err != nil {
+		return core.Token{}, fmt.Errorf("Unable to authenticate: %w", err)
+	}
+	return found, nil
+}
+
+// ValidateToken is Authenticate for clients that also send the user id
+func (as *AuthService) ValidateToken(user_id, token string) (core.Token, error) {
+	found, err := as.authenticate(token)
+	if err == nil && found.UserId != user_id {
+		err = TokenInvalidError
+	}
+	if err != nil {
+		return core.Token{}, fmt.Errorf("Unable to validate token for user_id: %s: %w", user_id, err)
+	}
+	return found, nil
+}
+
+func (as *AuthService) authenticate(token string) (core.Token, error) {
+	if as.accessLifetime != 0 {
+		claims, err := verifyAccessToken(as.accessKey, token, as.now())
+		if err != nil {
+			return core.Token{}, err
+		}
+		return core.Token{
+			Id:        claims.TokenId,
+			UserId:    claims.UserId,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		}, nil
+	}
+
+	found, err := as.findToken(token)
+	if err != nil {
+		return core.Token{}, err
+	}
+	err = as.touch(&found)
+	if err != nil {
+		return core.Token{}, err
+	}
+	return found, nil
+}
+
+func (as *AuthService) touch(token *core.Token) error {
+	now := as.now().UTC()
+	if now.Sub(token.LastUsedAt) < touchInterval {
+		return nil
+	}
+	token.LastUsedAt = now
+	token.ExpiresAt = now.Add(TokenLifetime)
+	return as.tokens.Store(token)
+}
+
+// Tokens lists the devices the user is logged in on
+func (as *AuthService) Tokens(user_id string) ([]core.Token, error) {
+	tokens, err := as.tokens.ListForUser(user_id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list tokens for user_id: %s: %w", user_id, err)
 	}
-	return user, nil
+	now := as.now()
+	var result []core.Token
+	for _, token := range tokens {
+		if now.Before(token.ExpiresAt) {
+			result = append(result, token)
+		}
+	}
+	return result, nil
 }
 
-func getHash(s string) string {
-	hash := md5.Sum([]byte(s))
-	return hex.EncodeToString(hash[:])
+// findToken returns the unexpired token with the secret token.
+// An expired token is deleted on the way.
+func (as *AuthService) findToken(token string) (core.Token, error) {
+	found, err := as.tokens.GetByHash(hashToken(as.tokenKey, token))
+	if err != nil {
+		return core.Token{}, TokenInvalidError
+	}
+	if !as.now().Before(found.ExpiresAt) {
+		err = as.tokens.Delete(found.Id)
+		if err != nil {
+			return core.Token{}, err
+		}
+		return core.Token{}, TokenExpiredError
+	}
+	return found, nil
+}
+
+// fetchUser checks the password of the user and tells if its hash has been
+// replaced, legacy and outdated hashes are upgraded while the password is at hand
+func (as *AuthService) fetchUser(email, password string) (core.User, bool, error) {
+	user, err := as.user.GetByEmail(email)
+	if err != nil {
+		// an unknown email is answered like a wrong password, not to tell who is registered
+		checkPassword(dummyHash, password)
+		return core.User{}, false, fmt.Errorf("Unable to fetch user for email: %s: %w: %w", email, LoginInvalidError, err)
+	}
+	if user.Guest {
+		// guests have no password to log in with
+		return core.User{}, false, fmt.Errorf("Unable to fetch user for email: %s: %w", email, LoginInvalidError)
+	}
+	ok, rehash, err := checkPassword(user.Password, password)
+	if err != nil {
+		return core.User{}, false, fmt.Errorf("Unable to fetch user for email: %s: %w", email, err)
+	}
+	if !ok {
+		return core.User{}, false, fmt.Errorf("Unable to fetch user for email: %s: %w", email, LoginInvalidError)
+	}
+	if rehash {
+		user.Password, err = hashPassword(password)
+		if err != nil {
+			return core.User{}, false, fmt.Errorf("Unable to fetch user for email: %s: %w", email, err)
+		}
+	}
+	return user, rehash, nil
 }
 
 func generateSecureToken(length int) string {
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/maps"
+)
+
+var testTokenKey = []byte("test token key")
+
+const testLinkURL = "https://bridge.test/"
+
+const testPassword = "correct horse"
+
+func TestAuthRegisterLogin(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	users := repos.Users
+	auth_service := New(store, users, repos.Tokens, repos.ActionTokens, &testMailer{}, testTokenKey, 0, testLinkURL)
+
+	err := auth_service.Register("user@bridge.test", testPassword, "User")
+	if err != nil {
+		panic(err)
+	}
+	stored, err := users.GetByEmail("user@bridge.test")
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$v=19$m=19456,t=2,p=1$"))
+	assert.NotContains(t, stored.Password, testPassword)
+
+	_, _, err = auth_service.Login("user@bridge.test", "wrong", "phone")
+	assert.ErrorIs(t, err, LoginInvalidError)
+	_, _, err = auth_service.Login("nobody@bridge.test", testPassword, "phone")
+	assert.ErrorIs(t, err, LoginInvalidError, "an unknown email must look like a wrong password")
+
+	user, credentials, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	assert.NoError(t, err)
+	assert.Empty(t, credentials.RefreshToken, "refresh tokens come with access tokens only")
+	token := credentials.Token
+	_, err = auth_service.ValidateToken(user.Id, token)
+	assert.NoError(t, err)
+	_, err = auth_service.ValidateToken(user.Id, "wrong")
+	assert.ErrorIs(t, err, TokenInvalidError)
+	_, err = auth_service.ValidateToken("someone else", token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+
+	tokens, err := repos.Tokens.ListForUser(user.Id)
+	if err != nil {
+		panic(err)
+	}
+	assert.NotEqual(t, token, tokens[0].Hash, "the token must be stored hashed")
+	other := New(store, users, repos.Tokens, repos.ActionTokens, &testMailer{}, []byte("other key"), 0, testLinkURL)
+	_, err = other.ValidateToken(user.Id, token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+}
+
+func TestAuthRegisterValidation(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, &testMailer{}, testTokenKey, 0, testLinkURL)
+
+	for _, test := range []struct {
+		email, password, nickname string
+		fields                    []string
+	}{
+		{"", testPassword, "User", []string{"email"}},
+		{"user", testPassword, "User", []string{"email"}},
+		{"User <user@bridge.test>", testPassword, "User", []string{"email"}},
+		{"user@localhost", testPassword, "User", []string{"email"}},
+		{"user@bridge.test", testPassword, "U", []string{"nickname"}},
+		{"user@bridge.test", testPassword, "_User", []string{"nickname"}},
+		{"user@bridge.test", testPassword, "User Name", []string{"nickname"}},
+		{"user@bridge.test", "short", "User", []string{"password"}},
+		{"user@bridge.test", "Password123", "User", []string{"password"}},
+		{"user@bridge.test", "aaaaaaaaaa", "User", []string{"password"}},
+		{"user@bridge.test", "NickName", "NickName", []string{"password"}},
+		{"user", "short", "", []string{"email", "nickname", "password"}},
+	} {
+		err := auth_service.Register(test.email, test.password, test.nickname)
+		var invalid *core.ValidationError
+		if assert.ErrorAs(t, err, &invalid, test) {
+			assert.ElementsMatch(t, test.fields, maps.Keys(invalid.Fields), test)
+		}
+	}
+
+	assert.NoError(t, auth_service.Register(" user@bridge.test ", testPassword, "Юзер_1"))
+	err := auth_service.Register("USER@bridge.test", testPassword, "юзер_1")
+	var invalid *core.ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, map[string]string{
+		"email":    "is already registered",
+		"nickname": "is taken",
+	}, invalid.Fields)
+}
+
+func TestAuthLoginUpgradesLegacyHash(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	users := repos.Users
+	auth_service := New(store, users, repos.Tokens, repos.ActionTokens, &testMailer{}, testTokenKey, 0, testLinkURL)
+	err := users.Store(&core.User{
+		Id:       "user",
+		Email:    "test1@bridge.test",
+		Password: "5f4dcc3b5aa765d61d8327deb882cf99", // MD5 of "password"
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	_, _, err = auth_service.Login("test1@bridge.test", "wrong", "")
+	assert.ErrorIs(t, err, LoginInvalidError)
+	stored, err := users.Get("user")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "5f4dcc3b5aa765d61d8327deb882cf99", stored.Password)
+
+	_, _, err = auth_service.Login("test1@bridge.test", "password", "")
+	assert.NoError(t, err)
+	stored, err = users.Get("user")
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+	_, _, err = auth_service.Login("test1@bridge.test", "password", "")
+	assert.NoError(t, err)
+}
+
+// newTokenService returns the service with a registered user
+// and a clock the test moves forward
+func newTokenService(accessLifetime time.Duration) (*AuthService, core.TokenRepository, *time.Time) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, &testMailer{}, testTokenKey, accessLifetime, testLinkURL)
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	auth_service.now = func() time.Time { return now }
+	err := auth_service.Register("user@bridge.test", testPassword, "User")
+	if err != nil {
+		panic(err)
+	}
+	return auth_service, repos.Tokens, &now
+}
+
+func TestAuthTokensPerDevice(t *testing.T) {
+	auth_service, _, now := newTokenService(0)
+
+	user, phone, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	if err != nil {
+		panic(err)
+	}
+	*now = now.Add(time.Hour)
+	_, desktop, err := auth_service.Login("user@bridge.test", testPassword, "desktop")
+	if err != nil {
+		panic(err)
+	}
+
+	phoneToken, err := auth_service.ValidateToken(user.Id, phone.Token)
+	assert.NoError(t, err, "logging in on the desktop must keep the phone logged in")
+	_, err = auth_service.ValidateToken(user.Id, desktop.Token)
+	assert.NoError(t, err)
+
+	tokens, err := auth_service.Tokens(user.Id)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, "phone", tokens[0].Device)
+	assert.Equal(t, "desktop", tokens[1].Device)
+
+	err = auth_service.Revoke(user.Id, "unknown")
+	assert.ErrorIs(t, err, core.TokenNotFoundError)
+	err = auth_service.Revoke(user.Id, phoneToken.Id)
+	assert.NoError(t, err)
+	_, err = auth_service.ValidateToken(user.Id, phone.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+
+	desktopToken, err := auth_service.Authenticate(desktop.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, desktopToken.UserId, "the token alone must tell the user")
+	err = auth_service.Revoke(user.Id, desktopToken.Id)
+	assert.NoError(t, err)
+	_, err = auth_service.Authenticate(desktop.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+	_, err = auth_service.ValidateToken(user.Id, desktop.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+
+	_, phone, _ = auth_service.Login("user@bridge.test", testPassword, "phone")
+	_, desktop, _ = auth_service.Login("user@bridge.test", testPassword, "desktop")
+	err = auth_service.RevokeAll(user.Id)
+	assert.NoError(t, err)
+	_, err = auth_service.ValidateToken(user.Id, phone.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+	_, err = auth_service.ValidateToken(user.Id, desktop.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+}
+
+func TestAuthTokenSlidingExpiry(t *testing.T) {
+	auth_service, tokens, now := newTokenService(0)
+
+	user, credentials, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	if err != nil {
+		panic(err)
+	}
+	secret := credentials.Token
+
+	*now = now.Add(TokenLifetime - time.Hour)
+	token, err := auth_service.ValidateToken(user.Id, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, *now, token.LastUsedAt)
+	assert.Equal(t, now.Add(TokenLifetime), token.ExpiresAt, "using a token must extend it")
+
+	*now = now.Add(TokenLifetime - time.Hour)
+	_, err = auth_service.ValidateToken(user.Id, secret)
+	assert.NoError(t, err)
+
+	*now = now.Add(TokenLifetime)
+	_, err = auth_service.ValidateToken(user.Id, secret)
+	assert.ErrorIs(t, err, TokenExpiredError)
+	stored, err := tokens.ListForUser(user.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, stored, "an expired token must be deleted")
+}
+
+func TestAuthAccessTokens(t *testing.T) {
+	auth_service, tokens, now := newTokenService(15 * time.Minute)
+
+	user, credentials, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	if err != nil {
+		panic(err)
+	}
+	assert.NotEmpty(t, credentials.RefreshToken)
+	assert.Equal(t, now.Add(15*time.Minute), credentials.ExpiresAt)
+	stored, err := tokens.ListForUser(user.Id)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := auth_service.Authenticate(credentials.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, token.UserId)
+	assert.Equal(t, stored[0].Id, token.Id, "the access token must tell the token it was issued for")
+	_, err = auth_service.Authenticate(credentials.RefreshToken)
+	assert.ErrorIs(t, err, TokenInvalidError, "a refresh token must not authenticate requests")
+	tampered := []byte(credentials.Token)
+	tampered[0] ^= 1
+	_, err = auth_service.Authenticate(string(tampered))
+	assert.ErrorIs(t, err, TokenInvalidError)
+	other := New(auth_service.uow, auth_service.user, tokens, auth_service.actions, &testMailer{}, []byte("other key"), 15*time.Minute, testLinkURL)
+	_, err = other.Authenticate(credentials.Token)
+	assert.ErrorIs(t, err, TokenInvalidError)
+
+	*now = now.Add(15 * time.Minute)
+	_, err = auth_service.Authenticate(credentials.Token)
+	assert.ErrorIs(t, err, TokenExpiredError)
+
+	refreshed, err := auth_service.Refresh(credentials.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, credentials.RefreshToken, refreshed.RefreshToken)
+	token, err = auth_service.Authenticate(refreshed.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, stored[0].Id, token.Id, "refreshing must keep the device logged in")
+	_, err = auth_service.Refresh(credentials.RefreshToken)
+	assert.ErrorIs(t, err, TokenInvalidError, "a refresh token must work once")
+
+	err = auth_service.Revoke(user.Id, token.Id)
+	assert.NoError(t, err)
+	_, err = auth_service.Authenticate(refreshed.Token)
+	assert.NoError(t, err, "an access token stays valid until it expires")
+	_, err = auth_service.Refresh(refreshed.RefreshToken)
+	assert.ErrorIs(t, err, TokenInvalidError)
+}
+
+func TestAuthRefreshDisabled(t *testing.T) {
+	auth_service, _, _ := newTokenService(0)
+
+	_, credentials, err := auth_service.Login("user@bridge.test", testPassword, "phone")
+	if err != nil {
+		panic(err)
+	}
+	_, err = auth_service.Refresh(credentials.Token)
+	assert.ErrorIs(t, err, RefreshDisabledError)
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		panic(err)
+	}
+	other, err := hashPassword("secret")
+	if err != nil {
+		panic(err)
+	}
+	assert.NotEqual(t, hash, other, "every hash must have its own salt")
+
+	ok, rehash, err := checkPassword(hash, "secret")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	outdated := strings.Replace(hash, "t=2", "t=1", 1)
+	ok, rehash, err = checkPassword(outdated, "secret")
+	assert.NoError(t, err)
+	assert.False(t, ok, "parameters are part of the hash")
+	assert.True(t, rehash)
+
+	_, _, err = checkPassword("$bcrypt$whatever", "secret")
+	assert.ErrorIs(t, err, PasswordHashInvalidError)
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+const (
+	// GuestLifetime is how long a guest lives since it last used a token
+	GuestLifetime = 7 * 24 * time.Hour
+	// guestAttempts is how many generated nicknames LoginGuest tries before giving up
+	guestAttempts = 5
+)
+
+// LoginGuest creates a guest, which has a generated nickname and neither
+// email nor password, and logs it in on device. The guest plays like any
+// user, it keeps the account by claiming it before it is collected.
+func (as *AuthService) LoginGuest(device string) (core.User, core.Credentials, error) {
+	user := core.User{
+		Id:    uuid.New().String(),
+		Role:  core.RolePlayer,
+		Guest: true,
+	}
+	var err error
+	for i := 0; i < guestAttempts; i++ {
+		user.Nickname = guestNickname()
+		err = as.user.Store(&user)
+		if !errors.Is(err, core.UserExistsError) {
+			break
+		}
+	}
+	if err != nil {
+		return core.User{}, core.Credentials{}, fmt.Errorf("Unable to login guest: %w", err)
+	}
+	credentials, err := as.issueToken(user.Id, device)
+	if err != nil {
+		return core.User{}, core.Credentials{}, fmt.Errorf("Unable to login guest: %w", err)
+	}
+	return user, credentials, nil
+}
+
+// Claim registers the guest user_id with the email, the password and, unless
+// it is empty, the nickname, checked as for Register. The user keeps its id,
+// so its rooms, games and logins stay with it.
+func (as *AuthService) Claim(user_id, email, password, nickname string) error {
+	user, err := as.user.Get(user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to claim account for user_id: %s: %w", user_id, err)
+	}
+	if !user.Guest {
+		return fmt.Errorf("Unable to claim account for user_id: %s: %w", user_id, core.NotGuestError)
+	}
+	if strings.TrimSpace(nickname) == "" {
+		nickname = user.Nickname
+	}
+	err = as.register(user, email, password, nickname)
+	if err != nil {
+		return fmt.Errorf("Unable to claim account for user_id: %s: %w", user_id, err)
+	}
+	return nil
+}
+
+// CollectGuests deletes the guests that have not used any of their tokens for
+// GuestLifetime, along with what belongs to them, and returns how many it deleted.
+// A guest with no token at all can no longer log in and goes too.
+func (as *AuthService) CollectGuests() (int, error) {
+	guests, err := as.user.ListGuests()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to collect guests: %w", err)
+	}
+	cutoff := as.now().Add(-GuestLifetime)
+	deleted := 0
+	for _, guest := range guests {
+		tokens, err := as.tokens.ListForUser(guest.Id)
+		if err != nil {
+			return deleted, fmt.Errorf("Unable to collect guests: %w", err)
+		}
+		active := false
+		for _, token := range tokens {
+			if token.LastUsedAt.After(cutoff) {
+				active = true
+				break
+			}
+		}
+		if active {
+			continue
+		}
+		err = as.user.Delete(guest.Id)
+		if err != nil {
+			return deleted, fmt.Errorf("Unable to collect guests: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// guestNickname is Guest and six random digits, a valid nickname
+func guestNickname() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "Guest" + strings.ToUpper(generateSecureToken(3))
+	}
+	return fmt.Sprintf("Guest%06d", n.Int64())
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthGuestClaim(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	mailer := &testMailer{}
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, mailer, testTokenKey, 0, testLinkURL)
+	err := auth_service.Register("user@bridge.test", testPassword, "User")
+	if err != nil {
+		panic(err)
+	}
+
+	guest, credentials, err := auth_service.LoginGuest("phone")
+	assert.NoError(t, err)
+	assert.True(t, guest.Guest)
+	assert.Regexp(t, `^Guest\d{6}$`, guest.Nickname)
+	assert.Empty(t, guest.Email)
+	_, err = auth_service.ValidateToken(guest.Id, credentials.Token)
+	assert.NoError(t, err)
+	other, _, err := auth_service.LoginGuest("laptop")
+	assert.NoError(t, err)
+	assert.NotEqual(t, guest.Id, other.Id)
+
+	_, _, err = auth_service.Login("", "", "phone")
+	assert.ErrorIs(t, err, LoginInvalidError, "guests have no password to log in with")
+	err = auth_service.SendVerification(guest.Id)
+	assert.ErrorIs(t, err, core.NotAllowedError)
+	mailer.sent = nil
+	assert.NoError(t, auth_service.ForgotPassword(""))
+	assert.Empty(t, mailer.sent)
+
+	var invalid *core.ValidationError
+	err = auth_service.Claim(guest.Id, "USER@bridge.test", testPassword, "")
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "is already registered", invalid.Fields["email"])
+	err = auth_service.Claim(guest.Id, "guest@bridge.test", testPassword, "user")
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "is taken", invalid.Fields["nickname"])
+
+	err = auth_service.Claim(guest.Id, "guest@bridge.test", testPassword, "")
+	assert.NoError(t, err)
+	claimed, err := repos.Users.Get(guest.Id)
+	assert.NoError(t, err)
+	assert.False(t, claimed.Guest)
+	assert.Equal(t, guest.Nickname, claimed.Nickname, "an empty nickname keeps the generated one")
+	assert.Equal(t, "guest@bridge.test", mailer.sent[0].to, "claiming mails the verification link")
+	_, err = auth_service.ValidateToken(guest.Id, credentials.Token)
+	assert.NoError(t, err, "the guest stays logged in")
+	user, _, err := auth_service.Login("guest@bridge.test", testPassword, "laptop")
+	assert.NoError(t, err)
+	assert.Equal(t, guest.Id, user.Id)
+
+	err = auth_service.Claim(guest.Id, "again@bridge.test", testPassword, "")
+	assert.ErrorIs(t, err, core.NotGuestError)
+}
+
+func TestAuthCollectGuests(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	auth_service := New(store, repos.Users, repos.Tokens, repos.ActionTokens, &testMailer{}, testTokenKey, 0, testLinkURL)
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	auth_service.now = func() time.Time { return now }
+
+	idle, _, err := auth_service.LoginGuest("phone")
+	if err != nil {
+		panic(err)
+	}
+	active, credentials, err := auth_service.LoginGuest("phone")
+	if err != nil {
+		panic(err)
+	}
+	claimed, _, err := auth_service.LoginGuest("phone")
+	if err != nil {
+		panic(err)
+	}
+	err = auth_service.Claim(claimed.Id, "claimed@bridge.test", testPassword, "")
+	if err != nil {
+		panic(err)
+	}
+	room := core.Room{Id: "room", Host: idle.Id, Users: []string{idle.Id, active.Id}}
+	err = repos.Rooms.Store(&room)
+	if err != nil {
+		panic(err)
+	}
+
+	now = now.Add(GuestLifetime - time.Hour)
+	_, err = auth_service.Authenticate(credentials.Token)
+	assert.NoError(t, err)
+	deleted, err := auth_service.CollectGuests()
+	assert.NoError(t, err)
+	assert.Zero(t, deleted, "nobody has been idle long enough")
+
+	now = now.Add(2 * time.Hour)
+	deleted, err = auth_service.CollectGuests()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = repos.Users.Get(idle.Id)
+	assert.ErrorIs(t, err, memory.NotFoundError)
+	tokens, err := repos.Tokens.ListForUser(idle.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+	_, err = repos.Rooms.Get("room")
+	assert.Error(t, err, "the rooms of the guest go with it")
+	_, err = repos.Users.Get(active.Id)
+	assert.NoError(t, err)
+	_, err = repos.Users.Get(claimed.Id)
+	assert.NoError(t, err, "a claimed account is no guest")
+}
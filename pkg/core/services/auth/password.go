@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var PasswordHashInvalidError = errors.New("Invalid password hash")
+
+// argon2id parameters for new hashes, stored hashes carry their own
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+}
+
+var defaultParams = argon2Params{
+	memory:  19 * 1024,
+	time:    2,
+	threads: 1,
+	keyLen:  32,
+}
+
+const saltLength = 16
+
+// dummyHash is what the password is checked against when there is no user,
+// so that an unknown email takes as long as a wrong password
+var dummyHash, _ = hashPassword("dummy password")
+
+// hashPassword returns the argon2id hash of password with a random salt,
+// encoded as $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := defaultParams
+	hash := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// checkPassword tells if password matches the encoded hash and if the hash
+// should be replaced, because it is a legacy MD5 one or has outdated parameters
+func checkPassword(encoded, password string) (ok bool, rehash bool, err error) {
+	if isLegacyHash(encoded) {
+		hash := md5.Sum([]byte(password))
+		ok = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(encoded)) == 1
+		return ok, true, nil
+	}
+
+	p, salt, expected, err := decodeHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	hash := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	ok = subtle.ConstantTimeCompare(hash, expected) == 1
+	return ok, p != defaultParams, nil
+}
+
+// isLegacyHash tells if encoded is an unsalted MD5 hash from before argon2id
+func isLegacyHash(encoded string) bool {
+	if len(encoded) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func decodeHash(encoded string) (argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2Params{}, nil, nil, PasswordHashInvalidError
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, PasswordHashInvalidError
+	}
+	var p argon2Params
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil {
+		return argon2Params{}, nil, nil, PasswordHashInvalidError
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, PasswordHashInvalidError
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2Params{}, nil, nil, PasswordHashInvalidError
+	}
+	p.keyLen = uint32(len(hash))
+	return p, salt, hash, nil
+}
+
+// hashToken keys the hash of a token with the server secret,
+// so stored hashes are of no use without it
+func hashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
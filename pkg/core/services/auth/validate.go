@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+const (
+	maxEmailLength    = 254
+	minNicknameLength = 3
+	maxNicknameLength = 20
+	minPasswordLength = 8
+	// maxPasswordLength bounds the work of hashing a password
+	maxPasswordLength = 128
+)
+
+// commonPasswords are the passwords guessed first, which long enough rules let through
+var commonPasswords = map[string]bool{
+	"password":    true,
+	"password1":   true,
+	"password123": true,
+	"12345678":    true,
+	"123456789":   true,
+	"1234567890":  true,
+	"qwertyui":    true,
+	"qwerty123":   true,
+	"11111111":    true,
+	"iloveyou":    true,
+	"abcdefgh":    true,
+	"abc12345":    true,
+	"sunshine":    true,
+	"football":    true,
+	"princess":    true,
+	"baseball":    true,
+	"welcome1":    true,
+	"letmein1":    true,
+}
+
+// validateRegistration checks the fields of a registration on their own,
+// whether the email or the nickname is taken is up to the repository
+func validateRegistration(email, password, nickname string) *core.ValidationError {
+	invalid := &core.ValidationError{}
+	validateEmail(invalid, email)
+	validateNickname(invalid, nickname)
+	validatePassword(invalid, password, email, nickname)
+	return invalid
+}
+
+func validateEmail(invalid *core.ValidationError, email string) {
+	if email == "" {
+		invalid.Add("email", "is required")
+		return
+	}
+	if len(email) > maxEmailLength {
+		invalid.Add("email", "is too long")
+		return
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		invalid.Add("email", "is not an email address")
+	}
+}
+
+// validateNickname allows letters, digits, '_', '-' and '.', starting with a letter or a digit
+func validateNickname(invalid *core.ValidationError, nickname string) {
+	length := utf8.RuneCountInString(nickname)
+	if length < minNicknameLength || length > maxNicknameLength {
+		invalid.Add("nickname", "must be 3 to 20 characters long")
+		return
+	}
+	for i, r := range nickname {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			continue
+		}
+		if i == 0 || !strings.ContainsRune("_-.", r) {
+			invalid.Add("nickname", "may have only letters, digits, '_', '-' and '.' and must start with a letter or a digit")
+			return
+		}
+	}
+}
+
+func validatePassword(invalid *core.ValidationError, password, email, nickname string) {
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength {
+		invalid.Add("password", "must be at least 8 characters long")
+		return
+	}
+	if length > maxPasswordLength {
+		invalid.Add("password", "must be at most 128 characters long")
+		return
+	}
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] || strings.Count(lower, lower[:1]) == len(lower) {
+		invalid.Add("password", "is too common")
+		return
+	}
+	if strings.EqualFold(password, email) || strings.EqualFold(password, nickname) ||
+		strings.EqualFold(password, strings.Split(email, "@")[0]) {
+		invalid.Add("password", "must differ from the email and the nickname")
+	}
+}
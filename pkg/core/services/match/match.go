@@ -0,0 +1,236 @@
+package match
+
+import (
+	"fmt"
+
+	"github.com/MrBTTF/gophercises/deck"
+	"github.com/google/uuid"
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
+)
+
+const DefaultLimit = 125
+
+var (
+	MatchFinishedError    = core.MatchFinishedError
+	RoundNotFinishedError = core.RoundNotFinishedError
+)
+
+// Penalty points for a card left in hand at the end of a round, as the house rules say.
+// Jack and Queen of Spades are weighted separately in cardPoints.
+var rankPoints = map[deck.Rank]int{
+	deck.Ace:   15,
+	deck.Ten:   10,
+	deck.Jack:  20,
+	deck.Queen: 10,
+	deck.King:  10,
+}
+
+const (
+	spadeJackPoints  = 40
+	spadeQueenPoints = 40
+	bridgeMultiplier = 2
+)
+
+// MatchService deals the rounds of a match through the session service,
+// sharing its unit of work so a round is tallied and dealt atomically
+type MatchService struct {
+	uow      core.UnitOfWork
+	sessions core.SessionServicePort
+}
+
+func New(uow core.UnitOfWork, sessions core.SessionServicePort) *MatchService {
+	return &MatchService{
+		uow:      uow,
+		sessions: sessions,
+	}
+}
+
+func (ms *MatchService) Create(room_id string, limit int) (match_id string, err error) {
+	err = ms.uow.Do(func(repos core.Repositories) error {
+		match_id, err = ms.create(repos, room_id, limit)
+		return err
+	})
+	return match_id, err
+}
+
+func (ms *MatchService) create(repos core.Repositories, room_id string, limit int) (string, error) {
+	sessions := ms.sessions.With(repos)
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	session_id, err := sessions.Create(room_id, "", nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create match for room %s: %w", room_id, err)
+	}
+	session, err := sessions.GetSession(session_id)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create match for room %s: %w", room_id, err)
+	}
+
+	match := &core.Match{
+		Id:      uuid.New().String(),
+		RoomId:  room_id,
+		Players: session.Players,
+		Limit:   limit,
+		Rounds: []core.Round{{
+			SessionId: session_id,
+			Dealer:    session.Players[0],
+		}},
+	}
+	err = repos.Matches.Store(match)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create match for room %s: %w", room_id, err)
+	}
+	return match.Id, nil
+}
+
+func (ms *MatchService) Get(match_id string) (match core.Match, err error) {
+	err = ms.uow.Do(func(repos core.Repositories) error {
+		match, err = repos.Matches.Get(match_id)
+		return err
+	})
+	return match, err
+}
+
+// NextRound tallies the finished round of the match and deals the next one
+// with the following player still in the room as dealer, unless somebody has
+// crossed the limit or fewer than two players are left.
+func (ms *MatchService) NextRound(match_id string) error {
+	return ms.uow.Do(func(repos core.Repositories) error {
+		return ms.nextRound(repos, match_id)
+	})
+}
+
+func (ms *MatchService) nextRound(repos core.Repositories, match_id string) error {
+	sessions := ms.sessions.With(repos)
+	match, err := repos.Matches.Get(match_id)
+	if err != nil {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+	}
+	if match.Finished {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, MatchFinishedError)
+	}
+
+	round := match.CurrentRound()
+	session, err := sessions.GetSession(round.SessionId)
+	if err != nil {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+	}
+	if !session.IsFinished() {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, RoundNotFinishedError)
+	}
+
+	hands := make(map[string][]core.Card, len(session.Players))
+	for _, player_id := range session.Players {
+		player, err := sessions.GetPlayer(player_id)
+		if err != nil {
+			return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+		}
+		hands[player_id] = player.Cards
+	}
+	round.Scores = RoundScores(&session, hands)
+	err = sessions.DeleteSession(session.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+	}
+
+	for _, score := range match.Scores() {
+		if score > match.Limit {
+			match.Finished = true
+		}
+	}
+	var players []string
+	dealer := ""
+	if !match.Finished {
+		room, err := repos.Rooms.Get(match.RoomId)
+		if err != nil {
+			return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+		}
+		players = playersLeft(&match, room.Users)
+		dealer = nextDealer(&match, players)
+		match.Finished = dealer == ""
+	}
+	if !match.Finished {
+		// who joined the room since the match started does not play in it
+		session_id, err := sessions.CreateForPlayers(match.RoomId, players, dealer, nil)
+		if err != nil {
+			return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+		}
+		match.Rounds = append(match.Rounds, core.Round{
+			SessionId: session_id,
+			Dealer:    dealer,
+		})
+	}
+
+	err = repos.Matches.Store(&match)
+	if err != nil {
+		return fmt.Errorf("Unable to start next round for match %s: %w", match_id, err)
+	}
+	return nil
+}
+
+// playersLeft are the players of the match who are still among users, the users of the room
+func playersLeft(match *core.Match, users []string) []string {
+	left := make([]string, 0, len(match.Players))
+	for _, player_id := range match.Players {
+		if slices.Contains(users, player_id) {
+			left = append(left, player_id)
+		}
+	}
+	return left
+}
+
+// nextDealer is the player of the match after the dealer of the current round
+// who is still among players, those left of the match. It is empty if fewer than
+// two are left, as there is nobody to play against.
+func nextDealer(match *core.Match, players []string) string {
+	if len(players) < 2 {
+		return ""
+	}
+	last := slices.Index(match.Players, match.CurrentRound().Dealer)
+	for i := 1; i <= len(match.Players); i++ {
+		player_id := match.Players[(last+i)%len(match.Players)]
+		if slices.Contains(players, player_id) {
+			return player_id
+		}
+	}
+	return ""
+}
+
+// RoundScores counts the penalty of every hand left when the session finished.
+// A declared bridge clears the winner's hand and doubles everybody else's penalty.
+func RoundScores(session *core.Session, hands map[string][]core.Card) map[string]int {
+	scores := make(map[string]int, len(hands))
+	for player_id, cards := range hands {
+		scores[player_id] = Points(cards)
+		if session.Bridge {
+			scores[player_id] *= bridgeMultiplier
+		}
+	}
+	if session.Bridge {
+		scores[session.Winner] = 0
+	}
+	return scores
+}
+
+// Points sums the penalty for the cards left in a hand.
+func Points(cards []core.Card) int {
+	points := 0
+	for _, card := range cards {
+		points += cardPoints(card)
+	}
+	return points
+}
+
+func cardPoints(card core.Card) int {
+	if card.Suit == deck.Spade {
+		switch card.Rank {
+		case deck.Jack:
+			return spadeJackPoints
+		case deck.Queen:
+			return spadeQueenPoints
+		}
+	}
+	return rankPoints[card.Rank]
+}
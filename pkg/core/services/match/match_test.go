@@ -0,0 +1,200 @@
+package match
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/services/room"
+	"github.com/mrbttf/bridge-server/pkg/core/services/session"
+	"github.com/mrbttf/bridge-server/pkg/events"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingMatches makes every match store inside the unit of work fail
+type failingMatches struct {
+	uow core.UnitOfWork
+	err error
+}
+
+func (f failingMatches) Do(fn func(core.Repositories) error) error {
+	return f.uow.Do(func(repos core.Repositories) error {
+		repos.Matches = failingMatchRepository{MatchRepository: repos.Matches, err: f.err}
+		return fn(repos)
+	})
+}
+
+// roomEvents records the room events published
+type roomEvents struct {
+	published []core.RoomEvent
+}
+
+func (r *roomEvents) Publish(event core.RoomEvent) {
+	r.published = append(r.published, event)
+}
+
+type failingMatchRepository struct {
+	core.MatchRepository
+	err error
+}
+
+func (f failingMatchRepository) Store(*core.Match) error {
+	return f.err
+}
+
+func TestPoints(t *testing.T) {
+	assert.Equal(t, 0, Points(nil))
+	assert.Equal(t, 0, Points([]core.Card{
+		core.NewCard(deck.Heart, deck.Six),
+		core.NewCard(deck.Club, deck.Nine),
+	}))
+	assert.Equal(t, 45, Points([]core.Card{
+		core.NewCard(deck.Heart, deck.Ace),
+		core.NewCard(deck.Heart, deck.Jack),
+		core.NewCard(deck.Diamond, deck.Queen),
+	}))
+	assert.Equal(t, 80, Points([]core.Card{
+		core.NewCard(deck.Spade, deck.Jack),
+		core.NewCard(deck.Spade, deck.Queen),
+	}))
+}
+
+func TestMatchScores(t *testing.T) {
+	match := core.Match{
+		Players: []string{"a", "b"},
+		Rounds: []core.Round{
+			{Scores: map[string]int{"a": 10, "b": 0}},
+			{Scores: map[string]int{"a": 5, "b": 40}},
+			{},
+		},
+	}
+	assert.Equal(t, map[string]int{"a": 15, "b": 40}, match.Scores())
+}
+
+func TestRoundScoresBridge(t *testing.T) {
+	hands := map[string][]core.Card{
+		"a": {core.NewCard(deck.Heart, deck.Ace)},
+		"b": {core.NewCard(deck.Heart, deck.King), core.NewCard(deck.Club, deck.Seven)},
+	}
+	session := &core.Session{Winner: "a"}
+	assert.Equal(t, map[string]int{"a": 15, "b": 10}, RoundScores(session, hands))
+
+	session.Bridge = true
+	assert.Equal(t, map[string]int{"a": 0, "b": 20}, RoundScores(session, hands))
+}
+
+func TestNextDealer(t *testing.T) {
+	match := core.Match{
+		Players: []string{"a", "b", "c"},
+		Rounds:  []core.Round{{Dealer: "a"}},
+	}
+	next := func(users ...string) string {
+		return nextDealer(&match, playersLeft(&match, users))
+	}
+	assert.Equal(t, "b", next("a", "b", "c"))
+	assert.Equal(t, "c", next("a", "c"), "a player who has left the room does not deal")
+	match.Rounds = append(match.Rounds, core.Round{Dealer: "c"})
+	assert.Equal(t, "a", next("a", "b", "c", "d"), "only the players of the match deal")
+	assert.Empty(t, next("c", "d"), "the match ends with a single player left")
+}
+
+func TestMatchNextRoundKeepsPlayers(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	for _, id := range []string{"a", "b", "late"} {
+		err := repos.Users.Store(&core.User{Id: id})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := repos.Rooms.Store(&core.Room{
+		Id:       "room",
+		Host:     "a",
+		Users:    []string{"a", "b"},
+		Open:     true,
+		Settings: core.DefaultRoomSettings(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	sessions := session.New(store, events.NewSessionBus(), room.New(repos.Rooms, repos.Users, &roomEvents{}))
+	match_service := New(store, sessions)
+	match_id, err := match_service.Create("room", 0)
+	if err != nil {
+		panic(err)
+	}
+
+	stored, err := repos.Rooms.Get("room")
+	if err != nil {
+		panic(err)
+	}
+	stored.Users = append(stored.Users, "late")
+	err = repos.Rooms.Store(&stored)
+	if err != nil {
+		panic(err)
+	}
+	match, err := match_service.Get(match_id)
+	if err != nil {
+		panic(err)
+	}
+	finished, err := repos.Sessions.Get(match.CurrentRound().SessionId)
+	if err != nil {
+		panic(err)
+	}
+	finished.Winner = "a"
+	err = repos.Sessions.Store(&finished)
+	if err != nil {
+		panic(err)
+	}
+
+	err = match_service.NextRound(match_id)
+	assert.NoError(t, err)
+	match, err = match_service.Get(match_id)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", match.CurrentRound().Dealer)
+	next, err := repos.Sessions.Get(match.CurrentRound().SessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, next.Players, "who joined during the match does not play in it")
+}
+
+func TestMatchCreateIsAtomic(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	for _, id := range []string{"a", "b"} {
+		err := repos.Users.Store(&core.User{Id: id})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := repos.Rooms.Store(&core.Room{
+		Id:       "room",
+		Host:     "a",
+		Users:    []string{"a", "b"},
+		Open:     true,
+		Settings: core.DefaultRoomSettings(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	published := &roomEvents{}
+	rooms := room.New(repos.Rooms, repos.Users, published)
+	sessions := session.New(store, events.NewSessionBus(), rooms)
+
+	storeErr := errors.New("Store failed")
+	_, err = New(failingMatches{uow: store, err: storeErr}, sessions).Create("room", 0)
+	assert.ErrorIs(t, err, storeErr)
+	stored, err := repos.Rooms.Get("room")
+	assert.NoError(t, err)
+	assert.True(t, stored.Open, "the room stays open if the match is not stored")
+	assert.Empty(t, published.published, "nobody is told the room closed")
+
+	_, err = New(store, sessions).Create("room", 0)
+	assert.NoError(t, err)
+	stored, err = repos.Rooms.Get("room")
+	assert.NoError(t, err)
+	assert.False(t, stored.Open)
+	assert.Len(t, published.published, 1)
+	assert.Equal(t, core.EventRoomClosed, published.published[0].Type)
+}
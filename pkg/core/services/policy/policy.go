@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
+)
+
+// Policy tells if a user may manage a room, a session or a match.
+// Hosts manage their rooms, players their sessions and matches and admins everything.
+type Policy struct {
+	users    core.UserRepository
+	rooms    core.RoomRepository
+	sessions core.SessionRepository
+	matches  core.MatchRepository
+}
+
+func New(users core.UserRepository, rooms core.RoomRepository, sessions core.SessionRepository, matches core.MatchRepository) *Policy {
+	return &Policy{
+		users:    users,
+		rooms:    rooms,
+		sessions: sessions,
+		matches:  matches,
+	}
+}
+
+// CanDeleteRoom allows the host of the room
+func (p *Policy) CanDeleteRoom(user_id, room_id string) error {
+	room, err := p.rooms.Get(room_id)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize deleting room %s by user_id %s: %w", room_id, user_id, err)
+	}
+	err = p.allow(user_id, room.Host == user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize deleting room %s by user_id %s: %w", room_id, user_id, err)
+	}
+	return nil
+}
+
+// CanCreateSession allows the users in the room
+func (p *Policy) CanCreateSession(user_id, room_id string) error {
+	room, err := p.rooms.Get(room_id)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize creating session in room %s by user_id %s: %w", room_id, user_id, err)
+	}
+	err = p.allow(user_id, slices.Contains(room.Users, user_id))
+	if err != nil {
+		return fmt.Errorf("Unable to authorize creating session in room %s by user_id %s: %w", room_id, user_id, err)
+	}
+	return nil
+}
+
+// CanCloseSession allows the players of the session
+func (p *Policy) CanCloseSession(user_id, session_id string) error {
+	session, err := p.sessions.Get(session_id)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize closing session %s by user_id %s: %w", session_id, user_id, err)
+	}
+	err = p.allow(user_id, session.HasPlayer(user_id))
+	if err != nil {
+		return fmt.Errorf("Unable to authorize closing session %s by user_id %s: %w", session_id, user_id, err)
+	}
+	return nil
+}
+
+// CanPlayMatch allows the players of the match and the host of its room
+func (p *Policy) CanPlayMatch(user_id, match_id string) error {
+	match, err := p.matches.Get(match_id)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize playing match %s by user_id %s: %w", match_id, user_id, err)
+	}
+	takesPart := slices.Contains(match.Players, user_id)
+	if !takesPart {
+		// the room is gone once everybody has left it, then only the players remain
+		room, err := p.rooms.Get(match.RoomId)
+		takesPart = err == nil && room.Host == user_id
+	}
+	err = p.allow(user_id, takesPart)
+	if err != nil {
+		return fmt.Errorf("Unable to authorize playing match %s by user_id %s: %w", match_id, user_id, err)
+	}
+	return nil
+}
+
+// allow lets the user through if it takes part or is an admin,
+// whose role is looked up only when needed
+func (p *Policy) allow(user_id string, takesPart bool) error {
+	if takesPart {
+		return nil
+	}
+	user, err := p.users.Get(user_id)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin() {
+		return core.NotAllowedError
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPolicy() *Policy {
+	repos := memory.NewStore().Repositories()
+	for _, user := range []core.User{
+		{Id: "host", Role: core.RolePlayer},
+		{Id: "guest", Role: core.RolePlayer},
+		{Id: "stranger", Role: core.RolePlayer},
+		{Id: "admin", Role: core.RoleAdmin},
+	} {
+		if err := repos.Users.Store(&user); err != nil {
+			panic(err)
+		}
+	}
+	err := repos.Rooms.Store(&core.Room{
+		Id:    "room",
+		Host:  "host",
+		Users: []string{"host", "guest"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = repos.Sessions.Store(&core.Session{
+		Id:      "session",
+		Players: []string{"host", "guest"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = repos.Matches.Store(&core.Match{
+		Id:      "match",
+		RoomId:  "room",
+		Players: []string{"guest", "admin"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return New(repos.Users, repos.Rooms, repos.Sessions, repos.Matches)
+}
+
+func TestPolicyRoom(t *testing.T) {
+	policy := newTestPolicy()
+
+	assert.NoError(t, policy.CanDeleteRoom("host", "room"))
+	assert.NoError(t, policy.CanDeleteRoom("admin", "room"))
+	assert.ErrorIs(t, policy.CanDeleteRoom("guest", "room"), core.NotAllowedError)
+	assert.ErrorIs(t, policy.CanDeleteRoom("stranger", "room"), core.NotAllowedError)
+	err := policy.CanDeleteRoom("host", "unknown")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, core.NotAllowedError)
+
+	assert.NoError(t, policy.CanCreateSession("host", "room"))
+	assert.NoError(t, policy.CanCreateSession("guest", "room"))
+	assert.NoError(t, policy.CanCreateSession("admin", "room"))
+	assert.ErrorIs(t, policy.CanCreateSession("stranger", "room"), core.NotAllowedError)
+}
+
+func TestPolicySession(t *testing.T) {
+	policy := newTestPolicy()
+
+	assert.NoError(t, policy.CanCloseSession("guest", "session"))
+	assert.NoError(t, policy.CanCloseSession("admin", "session"))
+	assert.ErrorIs(t, policy.CanCloseSession("stranger", "session"), core.NotAllowedError)
+	err := policy.CanCloseSession("guest", "unknown")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, core.NotAllowedError)
+}
+
+func TestPolicyMatch(t *testing.T) {
+	policy := newTestPolicy()
+
+	assert.NoError(t, policy.CanPlayMatch("guest", "match"))
+	assert.NoError(t, policy.CanPlayMatch("host", "match"), "the host may follow a match it does not play")
+	assert.ErrorIs(t, policy.CanPlayMatch("stranger", "match"), core.NotAllowedError)
+	err := policy.CanPlayMatch("guest", "unknown")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, core.NotAllowedError)
+}
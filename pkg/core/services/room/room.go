@@ -6,21 +6,39 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
 )
 
 var (
-	UserHasRoomError = errors.New("User has joined another room already")
+	UserHasRoomError     = errors.New("User has joined another room already")
+	NotHostError         = core.NotHostError
+	InvalidSettingsError = errors.New("Invalid room settings")
+	NotInRoomError       = core.NotInRoomError
 )
 
 type RoomService struct {
-	rooms core.RoomRepository
-	users core.UserRepository
+	rooms  core.RoomRepository
+	users  core.UserRepository
+	events core.RoomEventPublisher
+	// afterCommit holds events back until the unit of work the service works in has committed
+	afterCommit func(fn func())
 }
 
-func New(rooms core.RoomRepository, users core.UserRepository) *RoomService {
+func New(rooms core.RoomRepository, users core.UserRepository, events core.RoomEventPublisher) *RoomService {
 	return &RoomService{
-		rooms: rooms,
-		users: users,
+		rooms:  rooms,
+		users:  users,
+		events: events,
+	}
+}
+
+// With returns the service working inside the unit of work the repositories belong to
+func (rs *RoomService) With(repos core.Repositories) core.RoomServicePort {
+	return &RoomService{
+		rooms:       repos.Rooms,
+		users:       repos.Users,
+		events:      rs.events,
+		afterCommit: repos.AfterCommit,
 	}
 }
 
@@ -28,15 +46,17 @@ func (rs *RoomService) Create(host_id string) (string, error) {
 	room_id := uuid.New().String()
 
 	room := &core.Room{
-		Id:    room_id,
-		Host:  host_id,
-		Users: []string{host_id},
-		Open:  true,
+		Id:       room_id,
+		Host:     host_id,
+		Users:    []string{host_id},
+		Open:     true,
+		Settings: core.DefaultRoomSettings(),
 	}
 	err := rs.rooms.Store(room)
 	if err != nil {
 		return "", fmt.Errorf("Unable to create room: %w", err)
 	}
+	rs.publish(core.EventRoomCreated, room_id, host_id)
 	return room_id, nil
 }
 
@@ -65,6 +85,45 @@ func (rs *RoomService) Join(room_id string, user_id string) error {
 	if err != nil {
 		return fmt.Errorf("Unable to join room, room_id %s, user_id %s: %w", room_id, user_id, err)
 	}
+	rs.publish(core.EventUserJoined, room_id, user_id)
+	return nil
+}
+
+// Leave takes the user out of the room. The next user becomes the host
+// if the host leaves, the room is deleted when nobody is left.
+func (rs *RoomService) Leave(room_id string, user_id string) error {
+	room, err := rs.rooms.Get(room_id)
+	if err != nil {
+		return fmt.Errorf("Unable to leave room, room_id %s, user_id %s: %w", room_id, user_id, err)
+	}
+	idx := slices.Index(room.Users, user_id)
+	if idx == -1 {
+		return fmt.Errorf("Unable to leave room, room_id %s, user_id %s: %w", room_id, user_id, NotInRoomError)
+	}
+	room.Users = slices.Delete(room.Users, idx, idx+1)
+
+	if len(room.Users) == 0 {
+		err = rs.rooms.Delete(room_id)
+		if err != nil {
+			return fmt.Errorf("Unable to leave room, room_id %s, user_id %s: %w", room_id, user_id, err)
+		}
+		rs.publish(core.EventUserLeft, room_id, user_id)
+		rs.publish(core.EventRoomDeleted, room_id, "")
+		return nil
+	}
+
+	hostChanged := room.Host == user_id
+	if hostChanged {
+		room.Host = room.Users[0]
+	}
+	err = rs.rooms.Store(&room)
+	if err != nil {
+		return fmt.Errorf("Unable to leave room, room_id %s, user_id %s: %w", room_id, user_id, err)
+	}
+	rs.publish(core.EventUserLeft, room_id, user_id)
+	if hostChanged {
+		rs.publish(core.EventHostChanged, room_id, room.Host)
+	}
 	return nil
 }
 
@@ -72,19 +131,90 @@ func (rs *RoomService) List(open bool) ([]core.Room, error) {
 	return rs.rooms.List(open)
 }
 
+// Close takes the room off the list of open rooms, closing it again does nothing
 func (rs *RoomService) Close(room_id string) error {
 	room, err := rs.rooms.Get(room_id)
 	if err != nil {
 		return fmt.Errorf("Unable to close room, room_id %s: %w", room_id, err)
 	}
+	if !room.Open {
+		return nil
+	}
 	room.Open = false
 	err = rs.rooms.Store(&room)
 	if err != nil {
 		return fmt.Errorf("Unable to close room, room_id %s: %w", room_id, err)
 	}
+	rs.publish(core.EventRoomClosed, room_id, "")
+	return nil
+}
+
+func (rs *RoomService) UpdateSettings(room_id, user_id string, settings core.RoomSettings) error {
+	room, err := rs.rooms.Get(room_id)
+	if err != nil {
+		return fmt.Errorf("Unable to update settings, room_id %s: %w", room_id, err)
+	}
+	if room.Host != user_id {
+		return fmt.Errorf("Unable to update settings, room_id %s, user_id %s: %w", room_id, user_id, NotHostError)
+	}
+	if err := validateSettings(settings).Err(); err != nil {
+		return fmt.Errorf("Unable to update settings, room_id %s: %w: %w", room_id, InvalidSettingsError, err)
+	}
+	room.Settings = settings
+	err = rs.rooms.Store(&room)
+	if err != nil {
+		return fmt.Errorf("Unable to update settings, room_id %s: %w", room_id, err)
+	}
 	return nil
 }
 
 func (rs *RoomService) Delete(room_id string) error {
-	return rs.rooms.Delete(room_id)
+	err := rs.rooms.Delete(room_id)
+	if err != nil {
+		return err
+	}
+	rs.publish(core.EventRoomDeleted, room_id, "")
+	return nil
+}
+
+// publish tells subscribers about a change once it is stored,
+// a change the unit of work discards is not told about
+func (rs *RoomService) publish(event_type core.RoomEventType, room_id, user_id string) {
+	event := core.RoomEvent{
+		Type:   event_type,
+		RoomId: room_id,
+		UserId: user_id,
+	}
+	if rs.afterCommit != nil {
+		rs.afterCommit(func() { rs.events.Publish(event) })
+		return
+	}
+	rs.events.Publish(event)
+}
+
+// validateSettings tells what is wrong with each setting, under the name the clients send it with
+func validateSettings(settings core.RoomSettings) *core.ValidationError {
+	invalid := &core.ValidationError{}
+	if settings.DeckSize != core.ShortDeckSize && settings.DeckSize != core.FullDeckSize {
+		invalid.Add("deck_size", fmt.Sprintf("must be %d or %d", core.ShortDeckSize, core.FullDeckSize))
+	}
+	if settings.DealerHand < 1 {
+		invalid.Add("dealer_hand", "must have at least one card")
+	}
+	if settings.PlayerHand < 1 {
+		invalid.Add("player_hand", "must have at least one card")
+	}
+	if 1+settings.DealerHand+settings.PlayerHand > settings.DeckSize {
+		invalid.Add("deck_size", "is too small for the hands")
+	}
+	for _, effect := range settings.Rules.Effects {
+		if effect.Pull < 0 || effect.Skip < 0 {
+			invalid.Add("effects", "must not pull or skip less than zero")
+		} else if effect.Pull > settings.DeckSize {
+			invalid.Add("effects", "must not pull more cards than the deck has")
+		} else if effect.Skip > core.MaxEffectSkip {
+			invalid.Add("effects", fmt.Sprintf("must not skip more than %d players", core.MaxEffectSkip))
+		}
+	}
+	return invalid
 }
@@ -0,0 +1,63 @@
+package room
+
+import (
+	"testing"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockRoomEventPublisher struct {
+	events []core.RoomEvent
+}
+
+func (m *MockRoomEventPublisher) Publish(event core.RoomEvent) {
+	m.events = append(m.events, event)
+}
+
+func TestRoomLeave(t *testing.T) {
+	repos := memory.NewStore().Repositories()
+	rooms := repos.Rooms
+	publisher := &MockRoomEventPublisher{}
+	room_service := New(rooms, repos.Users, publisher)
+
+	room_id, err := room_service.Create("host")
+	if err != nil {
+		panic(err)
+	}
+	err = room_service.Join(room_id, "guest")
+	if err != nil {
+		panic(err)
+	}
+
+	err = room_service.Leave(room_id, "stranger")
+	assert.ErrorIs(t, err, NotInRoomError)
+
+	err = room_service.Leave(room_id, "host")
+	if err != nil {
+		panic(err)
+	}
+	room, err := rooms.Get(room_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "guest", room.Host)
+	assert.Equal(t, []string{"guest"}, room.Users)
+
+	err = room_service.Leave(room_id, "guest")
+	if err != nil {
+		panic(err)
+	}
+	_, err = rooms.Get(room_id)
+	assert.ErrorIs(t, err, memory.NotFoundError)
+
+	assert.Equal(t, []core.RoomEvent{
+		{Type: core.EventRoomCreated, RoomId: room_id, UserId: "host"},
+		{Type: core.EventUserJoined, RoomId: room_id, UserId: "guest"},
+		{Type: core.EventUserLeft, RoomId: room_id, UserId: "host"},
+		{Type: core.EventHostChanged, RoomId: room_id, UserId: "guest"},
+		{Type: core.EventUserLeft, RoomId: room_id, UserId: "guest"},
+		{Type: core.EventRoomDeleted, RoomId: room_id},
+	}, publisher.events)
+}
@@ -1,72 +1,146 @@
 package session
 
 import (
-	"errors"
 	"fmt"
+	"math/rand"
+	"time"
 
 	"github.com/MrBTTF/gophercises/deck"
 	"github.com/google/uuid"
 	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
 	"github.com/mrbttf/bridge-server/pkg/core/state"
+	"golang.org/x/exp/slices"
 )
 
 var (
-	CardNotFoundError            = errors.New("Card not found")
-	PlayerInSessionNotFoundError = errors.New("Player not found in session")
+	CardNotFoundError            = core.CardNotFoundError
+	PlayerInSessionNotFoundError = core.PlayerInSessionNotFoundError
+	SessionFinishedError         = core.SessionFinishedError
+	DeckExhaustedError           = core.DeckExhaustedError
+	SuitDemandError              = core.SuitDemandError
+	NotPlayersTurnError          = core.NotPlayersTurnError
+	NotEnoughCardsError          = core.NotEnoughCardsError
+	NoBridgeError                = core.NoBridgeError
+	IllegalMoveError             = core.IllegalMoveError
+	NotInRoomError               = core.NotInRoomError
 )
 
+var shuffleRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// SessionService runs every game action as one unit of work,
+// so the session and the hands of its players are stored together or not at all
 type SessionService struct {
-	sessions core.SessionRepository
-	players  core.PlayerRepository
-	users    core.UserRepository
-	rooms    core.RoomRepository
+	uow    core.UnitOfWork
+	events core.SessionEventPublisher
+	rooms  core.RoomServicePort
+}
+
+func New(uow core.UnitOfWork, events core.SessionEventPublisher, rooms core.RoomServicePort) *SessionService {
+	return &SessionService{
+		uow:    uow,
+		events: events,
+		rooms:  rooms,
+	}
 }
 
-func New(
-	sessions core.SessionRepository,
-	players core.PlayerRepository,
-	users core.UserRepository,
-	rooms core.RoomRepository,
-) *SessionService {
+// With returns the service working inside the unit of work the repositories belong to
+func (s *SessionService) With(repos core.Repositories) core.SessionServicePort {
 	return &SessionService{
-		sessions: sessions,
-		players:  players,
-		users:    users,
-		rooms:    rooms,
+		uow:    boundUnit{repos: repos},
+		events: s.events,
+		rooms:  s.rooms,
 	}
 }
 
-func (s *SessionService) GetSession(sessionId string) (core.Session, error) {
-	return s.sessions.Get(sessionId)
+// boundUnit runs the work with the repositories of a unit of work that is already open
+type boundUnit struct {
+	repos core.Repositories
 }
 
-func (s *SessionService) GetPlayer(sessionplayerId string) (core.Player, error) {
-	return s.players.Get(sessionplayerId)
+func (u boundUnit) Do(fn func(core.Repositories) error) error {
+	return fn(u.repos)
+}
+
+func (s *SessionService) GetSession(sessionId string) (session core.Session, err error) {
+	err = s.uow.Do(func(repos core.Repositories) error {
+		session, err = repos.Sessions.Get(sessionId)
+		return err
+	})
+	return session, err
+}
+
+func (s *SessionService) GetPlayer(sessionplayerId string) (player core.Player, err error) {
+	err = s.uow.Do(func(repos core.Repositories) error {
+		player, err = repos.Players.Get(sessionplayerId)
+		return err
+	})
+	return player, err
+}
+
+// Create deals a new session for the room and closes the room. The dealer gets the first turn,
+// the rest of the room follows in order; an empty dealer_id means the first user of the room.
+func (s *SessionService) Create(room_id string, dealer_id string, _deck []deck.Card) (session_id string, err error) {
+	return s.CreateForPlayers(room_id, nil, dealer_id, _deck)
+}
+
+// CreateForPlayers is Create dealing only to player_ids, which must be users of the room,
+// so that a match goes on with its own players. Nil player_ids means everybody in the room.
+func (s *SessionService) CreateForPlayers(room_id string, player_ids []string, dealer_id string, _deck []deck.Card) (session_id string, err error) {
+	err = s.uow.Do(func(repos core.Repositories) error {
+		session_id, err = create(repos, room_id, player_ids, dealer_id, _deck)
+		if err != nil {
+			return err
+		}
+		err = s.rooms.With(repos).Close(room_id)
+		if err != nil {
+			return fmt.Errorf("Unable to create session: %w", err)
+		}
+		return nil
+	})
+	return session_id, err
 }
 
-func (s *SessionService) Create(room_id string, _deck []deck.Card) (string, error) {
+func create(repos core.Repositories, room_id string, player_ids []string, dealer_id string, _deck []deck.Card) (string, error) {
+	room, err := repos.Rooms.Get(room_id)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create session: %w", err)
+	}
+	settings := room.Settings
+	if player_ids == nil {
+		player_ids = room.Users
+	}
+	for _, player_id := range player_ids {
+		if !slices.Contains(room.Users, player_id) {
+			return "", fmt.Errorf("Unable to create session: player %s: %w", player_id, NotInRoomError)
+		}
+	}
+
 	if _deck == nil {
-		_deck = core.NewDeck()
+		_deck = core.NewDeckOfSize(settings.DeckSize)
+	}
+	if len(_deck) < 1+settings.DealerHand+settings.PlayerHand*(len(player_ids)-1) {
+		return "", fmt.Errorf("Unable to create session: %w", NotEnoughCardsError)
 	}
 
 	_deck, table := popDeck(_deck, 1)
 
 	session_id := uuid.New().String()
 
-	room, err := s.rooms.Get(room_id)
+	order, err := rotateToDealer(player_ids, dealer_id)
 	if err != nil {
 		return "", fmt.Errorf("Unable to create session: %w", err)
 	}
 
-	players := make([]core.Player, 0, len(room.Users))
+	players := make([]core.Player, 0, len(order))
 
-	first_player_id := room.Users[0]
-	first_user, err := s.users.Get(first_player_id)
+	first_player_id := order[0]
+	first_user, err := repos.Users.Get(first_player_id)
 	if err != nil {
 		return "", fmt.Errorf("Unable to create session: %w", err)
 	}
 
-	_deck, cards := popDeck(_deck, 5)
+	_deck, cards := popDeck(_deck, settings.DealerHand)
 	players = append(players, core.Player{
 		Id:        first_player_id,
 		Cards:     cards,
@@ -74,19 +148,19 @@ func (s *SessionService) Create(room_id string, _deck []deck.Card) (string, erro
 		State:     state.StateWaitForTurn,
 		SessionId: session_id,
 	})
-	players[0].State, err = players[0].State.OnNextTurn(table[0])
+	players[0].State, err = players[0].State.OnNextTurn(settings.Rules, table[0])
 	if err != nil {
 		return "", fmt.Errorf("Unable to create session: %w", err)
 	}
 
-	for _, id := range room.Users[1:] {
+	for _, id := range order[1:] {
 
-		user, err := s.users.Get(id)
+		user, err := repos.Users.Get(id)
 		if err != nil {
 			return "", fmt.Errorf("Unable to create session: %w", err)
 		}
 
-		_deck, cards = popDeck(_deck, 4)
+		_deck, cards = popDeck(_deck, settings.PlayerHand)
 		players = append(players, core.Player{
 			Id:        id,
 			Cards:     cards,
@@ -98,17 +172,19 @@ func (s *SessionService) Create(room_id string, _deck []deck.Card) (string, erro
 
 	session := &core.Session{
 		Id:            session_id,
-		Players:       room.Users,
+		Players:       order,
 		Deck:          _deck,
 		Table:         table,
-		CurrentPlayer: room.Users[0],
+		CurrentPlayer: first_player_id,
+		Direction:     core.DirectionClockwise,
+		Rules:         settings.Rules,
 	}
-	err = s.sessions.Store(session)
+	err = repos.Sessions.Store(session)
 	if err != nil {
 		return "", fmt.Errorf("Unable to create session: %w", err)
 	}
 	for _, player := range players {
-		err = s.players.Store(&player)
+		err = repos.Players.Store(&player)
 		if err != nil {
 			return "", fmt.Errorf("Unable to create session: %w", err)
 		}
@@ -117,50 +193,100 @@ func (s *SessionService) Create(room_id string, _deck []deck.Card) (string, erro
 }
 
 func (s *SessionService) Pull(session_id, player_id string) error {
-	session, err := s.sessions.Get(session_id)
+	var session core.Session
+	err := s.uow.Do(func(repos core.Repositories) (err error) {
+		session, err = pull(repos, session_id, player_id)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		return err
+	}
+	s.publish(&session, core.EventCardPulled, player_id, nil)
+	return nil
+}
+
+func pull(repos core.Repositories, session_id, player_id string) (core.Session, error) {
+	session, err := repos.Sessions.Get(session_id)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
 	}
 	if !session.HasPlayer(player_id) {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+	}
+	if session.IsFinished() {
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, SessionFinishedError)
+	}
+	if session.CurrentPlayer != player_id {
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, NotPlayersTurnError)
+	}
+
+	if len(session.Deck) == 0 {
+		err = reshuffleTable(&session)
+		if err != nil {
+			return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		}
 	}
 
 	last_idx := len(session.Deck) - 1
 	card := session.Deck[last_idx]
 	session.Deck = session.Deck[:last_idx]
-	player, err := s.players.Get(player_id)
+	player, err := repos.Players.Get(player_id)
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
 	}
 
 	player.State, err = player.State.OnPull(card)
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s, card %s: %w", session_id, player_id, card, err)
 	}
 
 	player.Cards = append(player.Cards, card)
-	err = s.sessions.Store(&session)
+	err = repos.Sessions.Store(&session)
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
 	}
-	err = s.players.Store(&player)
+	err = repos.Players.Store(&player)
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+	}
+	return session, nil
+}
+
+// Lay puts the card on the table. A Jack may demand the suit
+// that has to follow it, for other cards suit must be nil.
+func (s *SessionService) Lay(session_id, player_id string, card core.Card, suit *core.Suit) error {
+	var session core.Session
+	err := s.uow.Do(func(repos core.Repositories) (err error) {
+		session, err = lay(repos, session_id, player_id, card, suit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.publish(&session, core.EventCardLaid, player_id, &card)
+	if session.IsFinished() {
+		s.publish(&session, core.EventGameOver, session.Winner, nil)
 	}
 	return nil
 }
 
-func (s *SessionService) Lay(session_id, player_id string, card core.Card) error {
-	session, err := s.sessions.Get(session_id)
+func lay(repos core.Repositories, session_id, player_id string, card core.Card, suit *core.Suit) (core.Session, error) {
+	session, err := repos.Sessions.Get(session_id)
 	if err != nil {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
 	}
 	if !session.HasPlayer(player_id) {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, PlayerInSessionNotFoundError)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, PlayerInSessionNotFoundError)
 	}
-	player, err := s.players.Get(player_id)
+	if session.IsFinished() {
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, SessionFinishedError)
+	}
+	if session.CurrentPlayer != player_id {
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, NotPlayersTurnError)
+	}
+	player, err := repos.Players.Get(player_id)
 	if err != nil {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
 	}
 
 	cardIdx := -1
@@ -171,83 +297,293 @@ func (s *SessionService) Lay(session_id, player_id string, card core.Card) error
 		}
 	}
 	if cardIdx == -1 {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, CardNotFoundError)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, CardNotFoundError)
 	}
-	err = layCardOnTable(session.Table, card)
+	if suit != nil && card.Rank != deck.Jack {
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, SuitDemandError)
+	}
+	err = layCardOnTable(&session, card)
 	if err != nil {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
 	}
 
 	session.Table = append(session.Table, card)
+	session.DemandedSuit = suit
 	player.Cards = append(player.Cards[:cardIdx], player.Cards[cardIdx+1:]...)
 
-	player.State, err = player.State.OnLay(card)
+	player.State, err = player.State.OnLay(session.Rules, card)
 	if err != nil {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
 	}
+	session.Pending = session.Pending.Add(session.Rules.Effect(card))
 
-	err = s.sessions.Store(&session)
+	// A Six has to be covered, so the player cannot go out on it
+	if len(player.Cards) == 0 && player.State != state.StateMustLay {
+		session.Winner = player_id
+	} else if session.HasBridge() {
+		player.State, err = player.State.OnBridge(card)
+		if err != nil {
+			return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		}
+	}
+
+	err = repos.Sessions.Store(&session)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+	}
+	err = repos.Players.Store(&player)
 	if err != nil {
-		return fmt.Errorf("Unable to lay for session %s, player %s, card %s: %w", session_id, player_id, card, err)
+		return core.Session{}, fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
 	}
-	err = s.players.Store(&player)
+	return session, nil
+}
+
+// Bridge lets the player who completed a bridge on the table declare it,
+// which ends the round in their favour, or decline it and go on with the turn
+func (s *SessionService) Bridge(session_id, player_id string, declare bool) error {
+	var session core.Session
+	err := s.uow.Do(func(repos core.Repositories) (err error) {
+		session, err = bridge(repos, session_id, player_id, declare)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to pull for session %s, player %s: %w", session_id, player_id, err)
+		return err
+	}
+	if session.IsFinished() {
+		s.publish(&session, core.EventGameOver, session.Winner, nil)
 	}
 	return nil
 }
 
+func bridge(repos core.Repositories, session_id, player_id string, declare bool) (core.Session, error) {
+	session, err := repos.Sessions.Get(session_id)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, err)
+	}
+	if !session.HasPlayer(player_id) {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+	}
+	if session.IsFinished() {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, SessionFinishedError)
+	}
+	if session.CurrentPlayer != player_id {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, NotPlayersTurnError)
+	}
+	player, err := repos.Players.Get(player_id)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, err)
+	}
+	if player.State != state.StateBridge {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, NoBridgeError)
+	}
+
+	topCard := session.Table[len(session.Table)-1]
+	player.State, err = player.State.OnDeclareBridge(session.Rules, declare, topCard)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, err)
+	}
+	if declare {
+		session.Winner = player_id
+		session.Bridge = true
+	}
+
+	err = repos.Sessions.Store(&session)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, err)
+	}
+	err = repos.Players.Store(&player)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to declare bridge for session %s, player %s: %w", session_id, player_id, err)
+	}
+	return session, nil
+}
+
+// NextTurn ends the turn of player_id, applies the effects of the cards laid
+// during it and passes the turn to the next player in the direction of play
 func (s *SessionService) NextTurn(session_id, player_id string) error {
-	session, err := s.sessions.Get(session_id)
+	var session core.Session
+	err := s.uow.Do(func(repos core.Repositories) (err error) {
+		session, err = nextTurn(repos, session_id, player_id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.publish(&session, core.EventTurnChanged, session.CurrentPlayer, nil)
+	return nil
+}
+
+func nextTurn(repos core.Repositories, session_id, player_id string) (core.Session, error) {
+	session, err := repos.Sessions.Get(session_id)
 	if err != nil {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
 	}
 	if !session.HasPlayer(player_id) {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+	}
+	if session.IsFinished() {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, SessionFinishedError)
+	}
+	if session.CurrentPlayer != player_id {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, NotPlayersTurnError)
 	}
-	session.CurrentPlayer = player_id
 
-	player, err := s.players.Get(player_id)
+	player, err := repos.Players.Get(player_id)
 	if err != nil {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, PlayerInSessionNotFoundError)
 	}
 
 	topCard := session.Table[len(session.Table)-1]
-	player.State, err = player.State.OnNextTurn(topCard)
+	player.State, err = player.State.OnEndTurn(topCard)
 	if err != nil {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+	}
+	err = repos.Players.Store(&player)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
 	}
 
-	err = s.sessions.Store(&session)
+	next_player_id, err := applyEffect(repos.Players, &session, session.Pending)
 	if err != nil {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
 	}
-	err = s.players.Store(&player)
+	session.Pending = rules.Effect{}
+
+	next_player, err := repos.Players.Get(next_player_id)
 	if err != nil {
-		return fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, next_player_id, PlayerInSessionNotFoundError)
 	}
-	return nil
+	next_player.State, err = next_player.State.OnNextTurn(session.Rules, topCard)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, next_player_id, err)
+	}
+	session.CurrentPlayer = next_player_id
+
+	err = repos.Sessions.Store(&session)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, player_id, err)
+	}
+	err = repos.Players.Store(&next_player)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to change turn for session %s, player %s: %w", session_id, next_player_id, err)
+	}
+	return session, nil
 }
 
 func (s *SessionService) DeleteSession(session_id string) error {
-	err := s.sessions.Delete(session_id)
+	err := s.uow.Do(func(repos core.Repositories) error {
+		return repos.Sessions.Delete(session_id)
+	})
 	if err != nil {
 		return fmt.Errorf("Unable to delete session %s: %w", session_id, err)
 	}
 	return nil
 }
 
-func layCardOnTable(table []deck.Card, card deck.Card) error {
-	if len(table) == 0 {
+func (s *SessionService) publish(session *core.Session, event_type core.SessionEventType, player_id string, card *core.Card) {
+	s.events.Publish(session.Id, core.SessionEvent{
+		Type:      event_type,
+		SessionId: session.Id,
+		PlayerId:  player_id,
+		Card:      card,
+	})
+}
+
+func layCardOnTable(session *core.Session, card deck.Card) error {
+	if len(session.Table) == 0 {
 		return nil
 	}
-	topCard := table[len(table)-1]
+	topCard := session.Table[len(session.Table)-1]
+	if demand := session.DemandedSuit; demand != nil {
+		if card.Rank == deck.Jack || card.Suit == *demand {
+			return nil
+		}
+		return fmt.Errorf("Cannot lay %s on %s, %s is demanded: %w", card, topCard, *demand, IllegalMoveError)
+	}
+	if topCard.Rank == card.Rank && !session.Rules.CanStack(card) {
+		return fmt.Errorf("Cannot stack %s on %s: %w", card, topCard, IllegalMoveError)
+	}
 	if topCard.Rank == deck.Jack || card.Rank == deck.Jack {
 		return nil
 	} else if topCard.Rank == card.Rank || topCard.Suit == card.Suit {
 		return nil
 	}
-	return fmt.Errorf("Cannot lay %s on %s", card, topCard)
+	return fmt.Errorf("Cannot lay %s on %s: %w", card, topCard, IllegalMoveError)
+}
+
+// applyEffect makes the player after the current one pull the penalty cards,
+// skips players if needed and returns who takes the turn
+func applyEffect(players core.PlayerRepository, session *core.Session, effect rules.Effect) (string, error) {
+	if effect.Reverse {
+		session.Direction = -session.Direction
+	}
+	next_player_id := session.NextPlayer()
+
+	if effect.Pull > 0 {
+		player, err := players.Get(next_player_id)
+		if err != nil {
+			return "", err
+		}
+		player.Cards = append(player.Cards, pullCards(session, effect.Pull)...)
+		err = players.Store(&player)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	current_player_id := session.CurrentPlayer
+	for i := 0; i < effect.Skip; i++ {
+		session.CurrentPlayer = next_player_id
+		next_player_id = session.NextPlayer()
+	}
+	session.CurrentPlayer = current_player_id
+	return next_player_id, nil
+}
+
+// pullCards takes up to n cards from the deck, reshuffling the table when needed
+func pullCards(session *core.Session, n int) []deck.Card {
+	cards := make([]deck.Card, 0, n)
+	for i := 0; i < n; i++ {
+		if len(session.Deck) == 0 && reshuffleTable(session) != nil {
+			break
+		}
+		var card []deck.Card
+		session.Deck, card = popDeck(session.Deck, 1)
+		cards = append(cards, card...)
+	}
+	return cards
+}
+
+// reshuffleTable keeps the top card on the table and shuffles the rest back into the deck
+func reshuffleTable(session *core.Session) error {
+	if len(session.Table) <= 1 {
+		return DeckExhaustedError
+	}
+	top_idx := len(session.Table) - 1
+	_deck := make([]deck.Card, top_idx)
+	copy(_deck, session.Table[:top_idx])
+	shuffleRand.Shuffle(len(_deck), func(i, j int) {
+		_deck[i], _deck[j] = _deck[j], _deck[i]
+	})
+
+	session.Deck = _deck
+	session.Table = []deck.Card{session.Table[top_idx]}
+	session.Reshuffles++
+	return nil
+}
+
+func rotateToDealer(users []string, dealer_id string) ([]string, error) {
+	if dealer_id == "" {
+		return users, nil
+	}
+	idx := slices.Index(users, dealer_id)
+	if idx == -1 {
+		return nil, PlayerInSessionNotFoundError
+	}
+	order := make([]string, 0, len(users))
+	order = append(order, users[idx:]...)
+	order = append(order, users[:idx]...)
+	return order, nil
 }
 
 func popDeck(_deck []deck.Card, n int) ([]deck.Card, []deck.Card) {
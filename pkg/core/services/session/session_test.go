@@ -2,12 +2,16 @@ package session
 
 import (
 	"errors"
+	"math/rand"
 	"testing"
 
 	"github.com/MrBTTF/gophercises/deck"
 	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/services/room"
+	"github.com/mrbttf/bridge-server/pkg/core/state"
+	"github.com/mrbttf/bridge-server/pkg/events"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
 
@@ -16,173 +20,546 @@ const (
 	room_id   = "test_room"
 )
 
-var (
-	NotFoundError = errors.New("Not found")
-)
+// failingUnitOfWork makes every player store inside the unit of work fail
+type failingUnitOfWork struct {
+	uow core.UnitOfWork
+	err error
+}
 
-type MockSessionRepository struct {
-	sessions map[string]core.Session
+func (f failingUnitOfWork) Do(fn func(core.Repositories) error) error {
+	return f.uow.Do(func(repos core.Repositories) error {
+		repos.Players = failingPlayerRepository{PlayerRepository: repos.Players, err: f.err}
+		return fn(repos)
+	})
 }
 
-func NewMockSessionRepository() *MockSessionRepository {
+type failingPlayerRepository struct {
+	core.PlayerRepository
+	err error
+}
 
-	return &MockSessionRepository{
-		sessions: map[string]core.Session{},
-	}
+func (f failingPlayerRepository) Store(*core.Player) error {
+	return f.err
 }
 
-func (m *MockSessionRepository) Get(session_id string) (core.Session, error) {
-	v, ok := m.sessions[session_id]
-	if !ok {
-		return core.Session{}, NotFoundError
-	}
-	return v, nil
+type MockSessionEventPublisher struct {
+	events []core.SessionEvent
 }
 
-func (m *MockSessionRepository) Store(session *core.Session) error {
-	m.sessions[session.Id] = *session
-	return nil
+func NewMockSessionEventPublisher() *MockSessionEventPublisher {
+	return &MockSessionEventPublisher{}
 }
 
-func (m *MockSessionRepository) Delete(session_id string) error {
-	delete(m.sessions, session_id)
-	return nil
+func (m *MockSessionEventPublisher) Publish(session_id string, event core.SessionEvent) {
+	m.events = append(m.events, event)
 }
 
-type MockPlayerRepository struct {
-	players map[string]core.Player
+func (m *MockSessionEventPublisher) types() []core.SessionEventType {
+	types := make([]core.SessionEventType, 0, len(m.events))
+	for _, event := range m.events {
+		types = append(types, event.Type)
+	}
+	return types
 }
 
-func NewMockPlayerRepository() *MockPlayerRepository {
+func TestSession(t *testing.T) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	sessions, players := repos.Sessions, repos.Players
+	err := repos.Users.Store(&core.User{
+		Id: player_id,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = repos.Rooms.Store(&core.Room{
+		Id:       room_id,
+		Host:     player_id,
+		Users:    []string{player_id},
+		Open:     true,
+		Settings: core.DefaultRoomSettings(),
+	})
+	if err != nil {
+		panic(err)
+	}
 
-	return &MockPlayerRepository{
-		players: map[string]core.Player{},
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	playerCard := core.NewCard(deck.Heart, deck.Queen)
+	setLastCards(_deck, tableCard, playerCard)
+	session_service := New(store, NewMockSessionEventPublisher(), room.New(repos.Rooms, repos.Users, events.NewRoomBus()))
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
 	}
-}
+	session_service.NextTurn(session_id, player_id)
 
-func (m *MockPlayerRepository) Get(player_id string) (core.Player, error) {
-	v, ok := m.players[player_id]
-	if !ok {
-		return core.Player{}, NotFoundError
+	err = session_service.Pull(session_id, player_id)
+	if err != nil {
+		panic(err)
 	}
-	return v, nil
-}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	if err != nil {
+		panic(err)
+	}
+	assert.NotContains(t, session.Deck, tableCard)
+	assert.Contains(t, player.Cards, playerCard)
 
-func (m *MockPlayerRepository) Store(player *core.Player) error {
-	m.players[player.Id] = *player
-	return nil
+	err = session_service.Lay(session_id, player_id, playerCard, nil)
+	if err != nil {
+		panic(err)
+	}
+	session, err = sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	player, err = players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Contains(t, session.Table, tableCard)
+	assert.NotContains(t, player.Cards, playerCard)
 }
 
-type MockUserRepository struct {
-	users map[string]core.User
+func setLastCards(_deck []deck.Card, tableCard, playerCard deck.Card) {
+	last := len(_deck) - 1
+	i := slices.Index(_deck, tableCard)
+	_deck[i], _deck[last] = _deck[last], _deck[i]
+	i = slices.Index(_deck, playerCard)
+	_deck[i], _deck[last-1] = _deck[last-1], _deck[i]
 }
 
-func NewMockUserRepository() *MockUserRepository {
+func TestSessionWinner(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
 
-	return &MockUserRepository{
-		users: map[string]core.User{},
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	playerCard := core.NewCard(deck.Heart, deck.Queen)
+	setLastCards(_deck, tableCard, playerCard)
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
 	}
-}
+	setPlayerCards(players, player_id, playerCard)
 
-func (m *MockUserRepository) Get(user_id string) (core.User, error) {
-	v, ok := m.users[user_id]
-	if !ok {
-		return core.User{}, NotFoundError
+	err = session_service.Lay(session_id, player_id, playerCard, nil)
+	if err != nil {
+		panic(err)
 	}
-	return v, nil
-}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, session.IsFinished())
+	assert.Equal(t, player_id, session.Winner)
 
-func (m *MockUserRepository) GetByEmail(email string) (core.User, error) {
-	return m.users[email], nil
+	err = session_service.Pull(session_id, player_id)
+	assert.ErrorIs(t, err, SessionFinishedError)
+	err = session_service.NextTurn(session_id, player_id)
+	assert.ErrorIs(t, err, SessionFinishedError)
 }
 
-func (m *MockUserRepository) GetForRoom(string) ([]core.User, error) {
-	return maps.Values(m.users), nil
-}
+func TestSessionCannotGoOutOnSix(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
 
-func (m *MockUserRepository) Store(player *core.User) error {
-	m.users[player.Id] = *player
-	return nil
-}
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	playerCard := core.NewCard(deck.Diamond, deck.Six)
+	setLastCards(_deck, tableCard, playerCard)
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
+	}
+	setPlayerCards(players, player_id, playerCard)
 
-type MockRoomRepository struct {
-	rooms map[string]core.Room
+	err = session_service.Lay(session_id, player_id, playerCard, nil)
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.False(t, session.IsFinished())
+
+	err = session_service.Pull(session_id, player_id)
+	assert.NoError(t, err)
 }
 
-func NewMockRoomRepository() *MockRoomRepository {
-	return &MockRoomRepository{
-		rooms: map[string]core.Room{},
+func TestSessionBridge(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
+
+	session_id, err := session_service.Create(room_id, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	session.Table = []deck.Card{
+		core.NewCard(deck.Spade, deck.Nine),
+		core.NewCard(deck.Diamond, deck.Nine),
+		core.NewCard(deck.Club, deck.Nine),
+	}
+	err = sessions.Store(&session)
+	if err != nil {
+		panic(err)
+	}
+	bridgeCard := core.NewCard(deck.Heart, deck.Nine)
+	setPlayerCards(players, player_id, bridgeCard, core.NewCard(deck.Heart, deck.Ten))
+
+	err = session_service.Bridge(session_id, player_id, true)
+	assert.ErrorIs(t, err, NoBridgeError)
+
+	err = session_service.Lay(session_id, player_id, bridgeCard, nil)
+	if err != nil {
+		panic(err)
+	}
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, state.StateBridge, player.State)
+	err = session_service.NextTurn(session_id, player_id)
+	assert.Error(t, err)
+
+	err = session_service.Bridge(session_id, player_id, true)
+	if err != nil {
+		panic(err)
 	}
+	session, err = sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.True(t, session.IsFinished())
+	assert.True(t, session.Bridge)
+	assert.Equal(t, player_id, session.Winner)
 }
 
-func (m *MockRoomRepository) Get(room_id string) (core.Room, error) {
-	v, ok := m.rooms[room_id]
-	if !ok {
-		return core.Room{}, NotFoundError
+func TestSessionBridgeDeclined(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
+
+	session_id, err := session_service.Create(room_id, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	session.Table = []deck.Card{
+		core.NewCard(deck.Spade, deck.Nine),
+		core.NewCard(deck.Diamond, deck.Nine),
+		core.NewCard(deck.Club, deck.Nine),
+	}
+	err = sessions.Store(&session)
+	if err != nil {
+		panic(err)
+	}
+	bridgeCard := core.NewCard(deck.Heart, deck.Nine)
+	setPlayerCards(players, player_id, bridgeCard, core.NewCard(deck.Heart, deck.Ten))
+
+	err = session_service.Lay(session_id, player_id, bridgeCard, nil)
+	if err != nil {
+		panic(err)
+	}
+	err = session_service.Bridge(session_id, player_id, false)
+	if err != nil {
+		panic(err)
+	}
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, state.StateCanLay, player.State)
+
+	err = session_service.NextTurn(session_id, player_id)
+	assert.NoError(t, err)
+	session, err = sessions.Get(session_id)
+	if err != nil {
+		panic(err)
 	}
-	return v, nil
+	assert.False(t, session.IsFinished())
+	assert.False(t, session.Bridge)
 }
 
-func (m *MockRoomRepository) GetByUserId(user_id string) (string, error) {
-	for _, room := range m.rooms {
-		if slices.Index(room.Users, user_id) != -1 {
-			return room.Id, nil
-		}
+func TestSessionEvents(t *testing.T) {
+	const second_player_id = "second_player"
+	session_service, _, players := newTestSessionService(second_player_id)
+	publisher := NewMockSessionEventPublisher()
+	session_service.events = publisher
+
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	playerCard := core.NewCard(deck.Heart, deck.Queen)
+	setLastCards(_deck, tableCard, playerCard)
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
+	}
+	setPlayerCards(players, player_id, playerCard, core.NewCard(deck.Heart, deck.Nine))
+
+	err = session_service.Lay(session_id, player_id, playerCard, nil)
+	if err != nil {
+		panic(err)
+	}
+	err = session_service.NextTurn(session_id, player_id)
+	if err != nil {
+		panic(err)
+	}
+	setPlayerCards(players, second_player_id, core.NewCard(deck.Heart, deck.Ten))
+	err = session_service.Lay(session_id, second_player_id, core.NewCard(deck.Heart, deck.Ten), nil)
+	if err != nil {
+		panic(err)
 	}
 
-	return "", NotFoundError
+	assert.Equal(t, []core.SessionEventType{
+		core.EventCardLaid,
+		core.EventTurnChanged,
+		core.EventCardLaid,
+		core.EventGameOver,
+	}, publisher.types())
+	assert.Equal(t, playerCard, *publisher.events[0].Card)
+	assert.Equal(t, second_player_id, publisher.events[1].PlayerId)
+	assert.Equal(t, second_player_id, publisher.events[3].PlayerId)
+	for _, event := range publisher.events {
+		assert.Equal(t, session_id, event.SessionId)
+	}
 }
 
-func (m *MockRoomRepository) Store(room *core.Room) error {
-	m.rooms[room.Id] = *room
-	return nil
+func TestSessionLayIsAtomic(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
+
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	playerCard := core.NewCard(deck.Heart, deck.Queen)
+	setLastCards(_deck, tableCard, playerCard)
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
+	}
+	setPlayerCards(players, player_id, playerCard, core.NewCard(deck.Heart, deck.Nine))
+
+	storeErr := errors.New("Store failed")
+	session_service.uow = failingUnitOfWork{uow: session_service.uow, err: storeErr}
+	err = session_service.Lay(session_id, player_id, playerCard, nil)
+	assert.ErrorIs(t, err, storeErr)
+
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, []deck.Card{tableCard}, session.Table)
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Len(t, player.Cards, 2)
 }
 
-func (m *MockRoomRepository) List(bool) ([]core.Room, error) {
-	return maps.Values(m.rooms), nil
+func TestSessionVersionConflict(t *testing.T) {
+	session_service, sessions, _ := newTestSessionService()
+
+	session_id, err := session_service.Create(room_id, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	stale, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+
+	err = session_service.Pull(session_id, player_id)
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, stale.Version+1, session.Version)
+
+	stale.Deck = stale.Deck[:len(stale.Deck)-1]
+	err = sessions.Store(&stale)
+	assert.ErrorIs(t, err, core.VersionConflictError)
 }
 
-func (m *MockRoomRepository) Delete(room_id string) error {
-	delete(m.rooms, room_id)
-	return nil
+func TestSessionJackDemandsSuit(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
+
+	_deck := core.NewDeck()
+	tableCard := core.NewCard(deck.Diamond, deck.Queen)
+	jack := core.NewCard(deck.Heart, deck.Jack)
+	setLastCards(_deck, tableCard, jack)
+	session_id, err := session_service.Create(room_id, "", _deck)
+	if err != nil {
+		panic(err)
+	}
+	clubTen := core.NewCard(deck.Club, deck.Ten)
+	spadeTen := core.NewCard(deck.Spade, deck.Ten)
+	diamondKing := core.NewCard(deck.Diamond, deck.King)
+	setPlayerCards(players, player_id, jack, clubTen, spadeTen, diamondKing)
+
+	suit := deck.Spade
+	err = session_service.Lay(session_id, player_id, diamondKing, &suit)
+	assert.ErrorIs(t, err, SuitDemandError)
+
+	err = session_service.Lay(session_id, player_id, jack, &suit)
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	if assert.NotNil(t, session.DemandedSuit) {
+		assert.Equal(t, deck.Spade, *session.DemandedSuit)
+	}
+
+	err = session_service.Lay(session_id, player_id, clubTen, nil)
+	assert.Error(t, err)
+
+	err = session_service.Lay(session_id, player_id, spadeTen, nil)
+	if err != nil {
+		panic(err)
+	}
+	session, err = sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, session.DemandedSuit)
 }
 
-func TestSession(t *testing.T) {
-	sessions := NewMockSessionRepository()
-	players := NewMockPlayerRepository()
-	users := NewMockUserRepository()
-	rooms := NewMockRoomRepository()
-	err := users.Store(&core.User{
-		Id: player_id,
-	})
+func TestSessionTurnOrder(t *testing.T) {
+	const second_player_id = "second_player"
+	session_service, sessions, players := newTestSessionService(second_player_id)
+
+	_deck := core.NewDeck()
+	setLastCards(_deck, core.NewCard(deck.Diamond, deck.Queen), core.NewCard(deck.Heart, deck.Queen))
+	session_id, err := session_service.Create(room_id, "", _deck)
 	if err != nil {
 		panic(err)
 	}
-	err = rooms.Store(&core.Room{
-		Id:    room_id,
-		Host:  player_id,
-		Users: []string{player_id},
-		Open:  true,
-	})
+
+	err = session_service.Pull(session_id, second_player_id)
+	assert.ErrorIs(t, err, NotPlayersTurnError)
+	err = session_service.NextTurn(session_id, second_player_id)
+	assert.ErrorIs(t, err, NotPlayersTurnError)
+
+	err = session_service.NextTurn(session_id, player_id)
+	assert.Error(t, err, "turn cannot end before laying or pulling")
+
+	err = session_service.Pull(session_id, player_id)
+	if err != nil {
+		panic(err)
+	}
+	err = session_service.NextTurn(session_id, player_id)
 	if err != nil {
 		panic(err)
 	}
 
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, second_player_id, session.CurrentPlayer)
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, state.StateWaitForTurn, player.State)
+	second_player, err := players.Get(second_player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.NotEqual(t, state.StateWaitForTurn, second_player.State)
+
+	session.Direction = core.DirectionCounterClockwise
+	assert.Equal(t, player_id, session.NextPlayer())
+}
+
+func TestSessionCardEffects(t *testing.T) {
+	const (
+		second_player_id = "second_player"
+		third_player_id  = "third_player"
+	)
+	session_service, sessions, players := newTestSessionService(second_player_id, third_player_id)
+
 	_deck := core.NewDeck()
-	tableCard := core.NewCard(deck.Diamond, deck.Queen)
-	playerCard := core.NewCard(deck.Heart, deck.Queen)
-	setLastCards(_deck, tableCard, playerCard)
-	session_service := New(sessions, players, users, rooms)
-	session_id, err := session_service.Create(room_id, _deck)
+	setLastCards(_deck, core.NewCard(deck.Diamond, deck.King), core.NewCard(deck.Heart, deck.King))
+	session_id, err := session_service.Create(room_id, "", _deck)
 	if err != nil {
 		panic(err)
 	}
-	session_service.NextTurn(session_id, player_id)
 
+	eight := core.NewCard(deck.Diamond, deck.Eight)
+	setPlayerCards(players, player_id, eight, core.NewCard(deck.Heart, deck.King))
+	err = session_service.Lay(session_id, player_id, eight, nil)
+	if err != nil {
+		panic(err)
+	}
 	err = session_service.Pull(session_id, player_id)
 	if err != nil {
 		panic(err)
 	}
+	err = session_service.NextTurn(session_id, player_id)
+	if err != nil {
+		panic(err)
+	}
+
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	second_player, err := players.Get(second_player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, third_player_id, session.CurrentPlayer, "Eight skips the next player")
+	assert.Len(t, second_player.Cards, 6, "Eight makes the next player pull two")
+	assert.Equal(t, state.StateWaitForTurn, second_player.State)
+	assert.True(t, session.Pending.IsNone())
+
+	queen := core.NewCard(deck.Diamond, deck.Queen)
+	setPlayerCards(players, third_player_id, queen, core.NewCard(deck.Club, deck.Nine))
+	err = session_service.Lay(session_id, third_player_id, queen, nil)
+	if err != nil {
+		panic(err)
+	}
+	err = session_service.NextTurn(session_id, third_player_id)
+	if err != nil {
+		panic(err)
+	}
+
+	session, err = sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, core.DirectionCounterClockwise, session.Direction, "Queen reverses the direction")
+	assert.Equal(t, second_player_id, session.CurrentPlayer)
+}
+
+func TestSessionRoomSettings(t *testing.T) {
+	const second_player_id = "second_player"
+	settings := core.DefaultRoomSettings()
+	settings.DeckSize = core.FullDeckSize
+	settings.DealerHand = 7
+	settings.PlayerHand = 6
+	settings.Rules.StackRanks = nil
+	session_service, sessions, players := newTestSessionServiceWithSettings(settings, second_player_id)
+
+	session_id, err := session_service.Create(room_id, "", nil)
+	if err != nil {
+		panic(err)
+	}
 	session, err := sessions.Get(session_id)
 	if err != nil {
 		panic(err)
@@ -191,13 +568,45 @@ func TestSession(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
+	second_player, err := players.Get(second_player_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Len(t, player.Cards, 7)
+	assert.Len(t, second_player.Cards, 6)
+	assert.Len(t, session.Deck, core.FullDeckSize-1-7-6)
+
+	session.Table = []deck.Card{core.NewCard(deck.Diamond, deck.Eight)}
+	err = sessions.Store(&session)
+	if err != nil {
+		panic(err)
+	}
+	eight := core.NewCard(deck.Heart, deck.Eight)
+	setPlayerCards(players, player_id, eight, core.NewCard(deck.Heart, deck.Nine))
+	err = session_service.Lay(session_id, player_id, eight, nil)
+	assert.Error(t, err, "Eights cannot be stacked")
+}
+
+func TestSessionPullReshufflesTable(t *testing.T) {
+	session_service, sessions, players := newTestSessionService()
+
+	session_id, err := session_service.Create(room_id, "", core.NewDeck())
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	topCard := session.Table[len(session.Table)-1]
+	session.Table = append(session.Deck, topCard)
+	session.Deck = nil
+	err = sessions.Store(&session)
 	if err != nil {
 		panic(err)
 	}
-	assert.NotContains(t, session.Deck, tableCard)
-	assert.Contains(t, player.Cards, playerCard)
 
-	err = session_service.Lay(session_id, player_id, playerCard)
+	err = session_service.Pull(session_id, player_id)
 	if err != nil {
 		panic(err)
 	}
@@ -205,22 +614,182 @@ func TestSession(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	player, err = players.Get(player_id)
+	player, err := players.Get(player_id)
 	if err != nil {
 		panic(err)
 	}
-	assert.Contains(t, session.Table, tableCard)
-	assert.NotContains(t, player.Cards, playerCard)
+	assert.Equal(t, 1, session.Reshuffles)
+	assert.Equal(t, []deck.Card{topCard}, session.Table)
+	assert.Len(t, session.Deck, len(core.NewDeck())-len(player.Cards)-1)
+	assert.NotContains(t, session.Deck, topCard)
 }
 
-func setLastCards(_deck []deck.Card, tableCard, playerCard deck.Card) {
-	for i, card := range _deck {
-		if card == tableCard {
-			_deck[i] = _deck[len(_deck)-1]
-		} else if card == playerCard {
-			_deck[i] = _deck[len(_deck)-2]
+func TestSessionPullDeckExhausted(t *testing.T) {
+	session_service, sessions, _ := newTestSessionService()
+
+	session_id, err := session_service.Create(room_id, "", core.NewDeck())
+	if err != nil {
+		panic(err)
+	}
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	session.Deck = nil
+	err = sessions.Store(&session)
+	if err != nil {
+		panic(err)
+	}
+
+	err = session_service.Pull(session_id, player_id)
+	assert.ErrorIs(t, err, DeckExhaustedError)
+}
+
+func TestSessionLongGame(t *testing.T) {
+	shuffleRand = rand.New(rand.NewSource(0))
+	session_service, sessions, players := newTestSessionService()
+
+	total := len(core.NewDeck())
+	session_id, err := session_service.Create(room_id, "", core.NewDeck())
+	if err != nil {
+		panic(err)
+	}
+
+	for turn := 0; turn < 1000; turn++ {
+		session, err := sessions.Get(session_id)
+		if err != nil {
+			panic(err)
+		}
+		if session.IsFinished() {
+			break
+		}
+		err = playTurn(session_service, sessions, players, session_id)
+		if errors.Is(err, DeckExhaustedError) {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		session, err = sessions.Get(session_id)
+		if err != nil {
+			panic(err)
+		}
+		player, err := players.Get(player_id)
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, total, len(session.Deck)+len(session.Table)+len(player.Cards))
+	}
+
+	session, err := sessions.Get(session_id)
+	if err != nil {
+		panic(err)
+	}
+	assert.Greater(t, session.Reshuffles, 0)
+}
+
+// playTurn pulls a card first and then lays at most one card
+// that fits unless the state machine demands more
+func playTurn(session_service *SessionService, sessions core.SessionRepository, players core.PlayerRepository, session_id string) error {
+	laid := false
+	for {
+		session, err := sessions.Get(session_id)
+		if err != nil {
+			return err
+		}
+		if session.IsFinished() {
+			return nil
+		}
+		player, err := players.Get(player_id)
+		if err != nil {
+			return err
+		}
+		card, ok := findCardToLay(&session, player.Cards)
+
+		switch player.State {
+		case state.StateMustLayOrPull:
+			err = session_service.Pull(session_id, player_id)
+		case state.StateMustLay:
+			if ok {
+				err = session_service.Lay(session_id, player_id, card, nil)
+			} else {
+				err = session_service.Pull(session_id, player_id)
+			}
+		case state.StateCanLay:
+			if ok && !laid {
+				laid = true
+				err = session_service.Lay(session_id, player_id, card, nil)
+			} else {
+				return session_service.NextTurn(session_id, player_id)
+			}
+		case state.StateBridge:
+			err = session_service.Bridge(session_id, player_id, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func findCardToLay(session *core.Session, cards []deck.Card) (deck.Card, bool) {
+	for _, card := range cards {
+		if layCardOnTable(session, card) == nil {
+			return card, true
 		}
 	}
-	_deck[len(_deck)-1] = tableCard
-	_deck[len(_deck)-2] = playerCard
+	return deck.Card{}, false
+}
+
+func newTestSessionService(others ...string) (*SessionService, core.SessionRepository, core.PlayerRepository) {
+	return newTestSessionServiceWithSettings(core.DefaultRoomSettings(), others...)
+}
+
+func newTestSessionServiceWithSettings(settings core.RoomSettings, others ...string) (*SessionService, core.SessionRepository, core.PlayerRepository) {
+	store := memory.NewStore()
+	repos := store.Repositories()
+	room_users := append([]string{player_id}, others...)
+	for _, id := range room_users {
+		err := repos.Users.Store(&core.User{
+			Id: id,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := repos.Rooms.Store(&core.Room{
+		Id:       room_id,
+		Host:     player_id,
+		Users:    room_users,
+		Open:     true,
+		Settings: settings,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return New(store, NewMockSessionEventPublisher(), room.New(repos.Rooms, repos.Users, events.NewRoomBus())), repos.Sessions, repos.Players
+}
+
+func setPlayerCards(players core.PlayerRepository, player_id string, cards ...deck.Card) {
+	player, err := players.Get(player_id)
+	if err != nil {
+		panic(err)
+	}
+	player.Cards = cards
+	err = players.Store(&player)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestSessionCreateForPlayers(t *testing.T) {
+	session_service, sessions, _ := newTestSessionService("other", "late")
+
+	_, err := session_service.CreateForPlayers(room_id, []string{player_id, "stranger"}, "", nil)
+	assert.ErrorIs(t, err, NotInRoomError)
+	session_id, err := session_service.CreateForPlayers(room_id, []string{player_id, "other"}, "other", nil)
+	assert.NoError(t, err)
+	session, err := sessions.Get(session_id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", player_id}, session.Players)
 }
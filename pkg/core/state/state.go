@@ -1,22 +1,17 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
 )
 
 type Card = deck.Card
 
-var mustLayCards = []deck.Rank{deck.Six, deck.Eight, deck.Ace}
-
-func ifMustLay(card Card) bool {
-	return card.Rank == deck.Six
-}
-
-func ifMustLayOrPull(card Card) bool {
-	return card.Rank == deck.Eight || card.Rank == deck.Ace
-}
+// IllegalMoveError is wrapped by the errors of actions the state does not allow
+var IllegalMoveError = errors.New("Move is not allowed by the rules")
 
 //go:generate stringer -type=Action
 type Action uint8
@@ -37,6 +32,7 @@ const (
 	StateMustLayOrPull
 	StateMustLay
 	StateCanLay
+	StateBridge
 )
 
 // func (s State) Next(action Action, card Card) (State, error) {
@@ -93,29 +89,29 @@ const (
 // 	return s, err
 // }
 
-func (s State) OnNextTurn(card Card) (State, error) {
-	if ifMustLay(card) {
+func (s State) OnNextTurn(r rules.Rules, card Card) (State, error) {
+	if r.MustLay(card) {
 		return StateMustLay, nil
 	}
 	return StateMustLayOrPull, nil
 }
 
-func (s State) OnLay(card Card) (State, error) {
+func (s State) OnLay(r rules.Rules, card Card) (State, error) {
 	switch s {
 	case StateMustLayOrPull:
 		fallthrough
 	case StateMustLay:
 		fallthrough
 	case StateCanLay:
-		if ifMustLay(card) {
+		if r.MustLay(card) {
 			return StateMustLay, nil
-		} else if ifMustLayOrPull(card) {
+		} else if r.MustLayOrPull(card) {
 			return StateMustLayOrPull, nil
 		} else {
 			return StateCanLay, nil
 		}
 	}
-	err := fmt.Errorf("Cannot move from state %s: action ActionLay, card %s", s, card)
+	err := fmt.Errorf("Cannot move from state %s: action ActionLay, card %s: %w", s, card, IllegalMoveError)
 	return s, err
 }
 
@@ -126,7 +122,7 @@ func (s State) OnPull(card Card) (State, error) {
 	case StateMustLay:
 		return StateMustLay, nil
 	}
-	err := fmt.Errorf("Cannot move from state %s: action ActionPull, card %s", s, card)
+	err := fmt.Errorf("Cannot move from state %s: action ActionPull, card %s: %w", s, card, IllegalMoveError)
 	return s, err
 }
 
@@ -134,6 +130,30 @@ func (s State) OnEndTurn(card Card) (State, error) {
 	if s == StateCanLay {
 		return StateWaitForTurn, nil
 	}
-	err := fmt.Errorf("Cannot move from state %s: action EndTurn, card %s", s, card)
+	err := fmt.Errorf("Cannot move from state %s: action EndTurn, card %s: %w", s, card, IllegalMoveError)
+	return s, err
+}
+
+// OnBridge is called right after a lay that made the last four cards on the table
+// share a rank: the player has to declare the bridge or decline it
+func (s State) OnBridge(card Card) (State, error) {
+	switch s {
+	case StateMustLayOrPull, StateMustLay, StateCanLay:
+		return StateBridge, nil
+	}
+	err := fmt.Errorf("Cannot move from state %s: action Bridge, card %s: %w", s, card, IllegalMoveError)
 	return s, err
 }
+
+// OnDeclareBridge ends the round when the bridge is declared, otherwise
+// the turn goes on as if the card was just laid
+func (s State) OnDeclareBridge(r rules.Rules, declare bool, card Card) (State, error) {
+	if s != StateBridge {
+		err := fmt.Errorf("Cannot move from state %s: action DeclareBridge, card %s: %w", s, card, IllegalMoveError)
+		return s, err
+	}
+	if declare {
+		return StateWaitForTurn, nil
+	}
+	return StateCanLay.OnLay(r, card)
+}
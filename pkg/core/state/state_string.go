@@ -12,11 +12,12 @@ func _() {
 	_ = x[StateMustLayOrPull-1]
 	_ = x[StateMustLay-2]
 	_ = x[StateCanLay-3]
+	_ = x[StateBridge-4]
 }
 
-const _State_name = "StateWaitForTurnStateMustLayOrPullStateMustLayStateCanLay"
+const _State_name = "StateWaitForTurnStateMustLayOrPullStateMustLayStateCanLayStateBridge"
 
-var _State_index = [...]uint8{0, 16, 34, 46, 57}
+var _State_index = [...]uint8{0, 16, 34, 46, 57, 68}
 
 func (i State) String() string {
 	if i >= State(len(_State_index)-1) {
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,7 +19,7 @@ func TestStateMustLayOrPull(t *testing.T) {
 	state := StateWaitForTurn
 
 	card := NewCard(deck.Heart, deck.Ten)
-	state, err := state.OnNextTurn(card)
+	state, err := state.OnNextTurn(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLayOrPull.String())
 	}
@@ -27,7 +28,7 @@ func TestStateMustLayOrPull(t *testing.T) {
 		assert.Equal(t, state.String(), StateCanLay.String())
 	}
 
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateCanLay.String())
 	}
@@ -41,17 +42,17 @@ func TestStateMustLayOrPullFor8Ace(t *testing.T) {
 	state := StateWaitForTurn
 
 	card := NewCard(deck.Heart, deck.Eight)
-	state, err := state.OnNextTurn(card)
+	state, err := state.OnNextTurn(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLayOrPull.String())
 	}
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLayOrPull.String())
 	}
 
 	card = NewCard(deck.Heart, deck.Ace)
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLayOrPull.String())
 	}
@@ -63,7 +64,7 @@ func TestStateMustLayOrPullFor8Ace(t *testing.T) {
 	}
 
 	card = NewCard(deck.Heart, deck.Six)
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLay.String())
 	}
@@ -75,7 +76,7 @@ func TestStateMustLayOrPullFor8Ace(t *testing.T) {
 	}
 
 	card = NewCard(deck.Heart, deck.King)
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateCanLay.String())
 	}
@@ -90,7 +91,7 @@ func TestStateMustLay(t *testing.T) {
 	state := StateWaitForTurn
 
 	card := NewCard(deck.Heart, deck.Six)
-	state, err := state.OnNextTurn(card)
+	state, err := state.OnNextTurn(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateMustLay.String())
 	}
@@ -104,7 +105,7 @@ func TestStateMustLay(t *testing.T) {
 	}
 
 	card = NewCard(deck.Spade, deck.Ten)
-	state, err = state.OnLay(card)
+	state, err = state.OnLay(rules.Default(), card)
 	if assert.NoError(t, err) {
 		assert.Equal(t, state.String(), StateCanLay.String())
 	}
@@ -114,3 +115,33 @@ func TestStateMustLay(t *testing.T) {
 		assert.Equal(t, state.String(), StateWaitForTurn.String())
 	}
 }
+
+func TestStateBridge(t *testing.T) {
+	state := StateCanLay
+
+	card := NewCard(deck.Heart, deck.Nine)
+	state, err := state.OnBridge(card)
+	if assert.NoError(t, err) {
+		assert.Equal(t, state.String(), StateBridge.String())
+	}
+	_, err = state.OnLay(rules.Default(), card)
+	assert.Error(t, err)
+	_, err = state.OnEndTurn(card)
+	assert.Error(t, err)
+
+	declined, err := state.OnDeclareBridge(rules.Default(), false, card)
+	if assert.NoError(t, err) {
+		assert.Equal(t, declined.String(), StateCanLay.String())
+	}
+
+	card = NewCard(deck.Heart, deck.Six)
+	declined, err = state.OnDeclareBridge(rules.Default(), false, card)
+	if assert.NoError(t, err) {
+		assert.Equal(t, declined.String(), StateMustLay.String())
+	}
+
+	declared, err := state.OnDeclareBridge(rules.Default(), true, card)
+	if assert.NoError(t, err) {
+		assert.Equal(t, declared.String(), StateWaitForTurn.String())
+	}
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError tells what is wrong with each invalid field of a request,
+// Fields maps the name of the field to the message for it
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (ve *ValidationError) Error() string {
+	names := make([]string, 0, len(ve.Fields))
+	for name := range ve.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, fmt.Sprintf("%s %s", name, ve.Fields[name]))
+	}
+	return "Invalid " + strings.Join(messages, ", ")
+}
+
+// Add records the first thing wrong with field
+func (ve *ValidationError) Add(field, message string) {
+	if ve.Fields == nil {
+		ve.Fields = make(map[string]string)
+	}
+	if _, ok := ve.Fields[field]; !ok {
+		ve.Fields[field] = message
+	}
+}
+
+// Err returns the error if any field is invalid and nil otherwise
+func (ve *ValidationError) Err() error {
+	if len(ve.Fields) == 0 {
+		return nil
+	}
+	return ve
+}
@@ -7,9 +7,22 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/mrbttf/bridge-server/pkg/config"
 	"github.com/mrbttf/bridge-server/pkg/log"
+	_ "modernc.org/sqlite"
 )
 
-func New(config *config.Config) (*sql.DB, error) {
+// New connects to the database picked by DBDriver, postgres if it is not set
+func New(cfg *config.Config) (*sql.DB, error) {
+	switch cfg.DBDriver {
+	case "", config.DriverPostgres:
+		return newPostgres(cfg)
+	case config.DriverSQLite:
+		return NewSQLite(cfg.DBName)
+	default:
+		return nil, fmt.Errorf("Unknown database driver %s", cfg.DBDriver)
+	}
+}
+
+func newPostgres(config *config.Config) (*sql.DB, error) {
 	connectionString := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", config.DBUser, config.DBPassword, config.DBHost, config.DBName)
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
@@ -22,3 +35,23 @@ func New(config *config.Config) (*sql.DB, error) {
 	log.Info("Connected to database")
 	return db, nil
 }
+
+// NewSQLite opens the database file at path.
+// Transactions take the write lock when they begin, so concurrent units of work
+// wait for each other instead of failing on commit.
+func NewSQLite(path string) (*sql.DB, error) {
+	connectionString := fmt.Sprintf(
+		"file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path,
+	)
+	db, err := sql.Open("sqlite", connectionString)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Connected to database ", path)
+	return db, nil
+}
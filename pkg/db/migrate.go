@@ -0,0 +1,320 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrbttf/bridge-server/db/migrations"
+	"github.com/mrbttf/bridge-server/pkg/config"
+	"golang.org/x/exp/slices"
+)
+
+var MigrationOrderError = errors.New("Migration is older than the latest applied one")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations reads the migrations of driver sorted by version
+func Migrations(driver string) ([]Migration, error) {
+	files, err := fs.Glob(migrations.FS, path.Join(driver, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No migrations for database driver %s", driver)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("Unable to parse migration file name %s", base)
+		}
+		number, name, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("Unable to parse migration file name %s", base)
+		}
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse migration file name %s: %w", base, err)
+		}
+		content, err := fs.ReadFile(migrations.FS, file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("Migrations %s and %s share version %d", migration.Name, name, version)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("Migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	slices.SortFunc(result, func(a, b Migration) bool {
+		return a.Version < b.Version
+	})
+	return result, nil
+}
+
+// Migrator applies migrations and keeps track of them in the schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	if driver == "" {
+		driver = config.DriverPostgres
+	}
+	migrations, err := Migrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+const CreateSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    name text NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)
+`
+
+const SelectSchemaMigrations = `
+SELECT version, applied_at
+FROM schema_migrations
+`
+
+const InsertSchemaMigration = `
+INSERT INTO schema_migrations (version, name, applied_at)
+VALUES ($1, $2, $3)
+`
+
+const DeleteSchemaMigration = `
+DELETE FROM schema_migrations
+WHERE version = $1
+`
+
+// LockMigrations keeps other migrators waiting until the transaction ends,
+// its key is arbitrary but must stay the same across releases
+const LockMigrations = `
+SELECT pg_advisory_xact_lock(72027326)
+`
+
+// querier is what reading schema_migrations needs, in or out of a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// applied returns when every applied migration was applied, by version
+func (m *Migrator) applied(q querier) (map[int]time.Time, error) {
+	_, err := q.Exec(CreateSchemaMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create schema_migrations: %w", err)
+	}
+	rows, err := q.Query(SelectSchemaMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("Unable to read schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		result = append(result, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return result, nil
+}
+
+// lock begins the transaction a whole run goes in, so that replicas starting
+// together do not apply the same migration twice. Postgres waits on an advisory
+// lock, SQLite transactions hold the write lock from the start already.
+func (m *Migrator) lock() (*sql.Tx, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to lock migrations: %w", err)
+	}
+	if m.driver == config.DriverPostgres {
+		_, err = tx.Exec(LockMigrations)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("Unable to lock migrations: %w", err)
+		}
+	}
+	return tx, nil
+}
+
+// Up applies every pending migration in order and returns the ones it has applied.
+// Migrations only go forward, so a pending migration older than an applied one
+// is an error. The migrations applied before a failing one are kept.
+func (m *Migrator) Up() ([]Migration, error) {
+	tx, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	// Rollback does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	result, err := m.up(tx)
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("Unable to commit migrations: %w", commitErr))
+	}
+	return result, err
+}
+
+func (m *Migrator) up(tx *sql.Tx) ([]Migration, error) {
+	applied, err := m.applied(tx)
+	if err != nil {
+		return nil, err
+	}
+	latest := 0
+	for version := range applied {
+		if version > latest {
+			latest = version
+		}
+	}
+
+	var result []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if migration.Version < latest {
+			return result, fmt.Errorf("Unable to apply migration %d_%s: %w", migration.Version, migration.Name, MigrationOrderError)
+		}
+		err := run(tx, migration.Up, InsertSchemaMigration, migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return result, fmt.Errorf("Unable to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// Down reverts the latest applied migration, it returns nil if there is none
+func (m *Migrator) Down() (*Migration, error) {
+	tx, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := m.down(tx)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to commit migrations: %w", err)
+	}
+	return result, nil
+}
+
+func (m *Migrator) down(tx *sql.Tx) (*Migration, error) {
+	applied, err := m.applied(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("Migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		err := run(tx, migration.Down, DeleteSchemaMigration, migration.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		return &migration, nil
+	}
+	return nil, nil
+}
+
+// Seed loads fixture data, it runs outside of schema_migrations tracking
+func (m *Migrator) Seed(fixture string) error {
+	_, err := m.db.Exec(fixture)
+	if err != nil {
+		return fmt.Errorf("Unable to load fixture: %w", err)
+	}
+	return nil
+}
+
+// run executes script and records it in schema_migrations under a savepoint,
+// so that a failing script leaves tx as it was
+func run(tx *sql.Tx, script string, record string, args ...any) error {
+	_, err := tx.Exec(`SAVEPOINT migration`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(script)
+	if err == nil {
+		_, err = tx.Exec(record, args...)
+	}
+	if err != nil {
+		_, rollbackErr := tx.Exec(`ROLLBACK TO SAVEPOINT migration`)
+		return errors.Join(err, rollbackErr)
+	}
+	_, err = tx.Exec(`RELEASE SAVEPOINT migration`)
+	return err
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrbttf/bridge-server/db/fixtures"
+	"github.com/mrbttf/bridge-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLite(t *testing.T) *sql.DB {
+	sqlDB, err := NewSQLite(filepath.Join(t.TempDir(), "bridge.db"))
+	if err != nil {
+		panic(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	postgres, err := Migrations(config.DriverPostgres)
+	assert.NoError(t, err)
+	sqlite, err := Migrations(config.DriverSQLite)
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(postgres), len(sqlite))
+	for i := range postgres {
+		assert.Equal(t, postgres[i].Version, sqlite[i].Version)
+		assert.Equal(t, postgres[i].Name, sqlite[i].Name)
+		assert.NotEmpty(t, postgres[i].Down)
+		assert.NotEmpty(t, sqlite[i].Down)
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	sqlDB := newTestSQLite(t)
+	migrator, err := NewMigrator(sqlDB, config.DriverSQLite)
+	if err != nil {
+		panic(err)
+	}
+
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		assert.False(t, status.Applied)
+	}
+
+	applied, err := migrator.Up()
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(migrator.migrations))
+	applied, err = migrator.Up()
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err = migrator.Status()
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied)
+		assert.False(t, status.AppliedAt.IsZero())
+	}
+
+	_, err = sqlDB.Exec(`INSERT INTO users (user_id) VALUES ('user')`)
+	assert.NoError(t, err)
+	applied, err = migrator.Up()
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+	var users int
+	err = sqlDB.QueryRow(`SELECT count(*) FROM users`).Scan(&users)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, users, "migrating again must keep the data")
+
+	latest := migrator.migrations[len(migrator.migrations)-1]
+	reverted, err := migrator.Down()
+	assert.NoError(t, err)
+	assert.Equal(t, latest.Version, reverted.Version)
+	statuses, err = migrator.Status()
+	assert.NoError(t, err)
+	assert.False(t, statuses[len(statuses)-1].Applied)
+}
+
+func TestMigratorUpConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	const replicas = 3
+	results := make(chan int, replicas)
+	errs := make(chan error, replicas)
+	for i := 0; i < replicas; i++ {
+		sqlDB, err := NewSQLite(path)
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { sqlDB.Close() })
+		migrator, err := NewMigrator(sqlDB, config.DriverSQLite)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			applied, err := migrator.Up()
+			results <- len(applied)
+			errs <- err
+		}()
+	}
+
+	total := 0
+	for i := 0; i < replicas; i++ {
+		total += <-results
+		assert.NoError(t, <-errs)
+	}
+	migrations, err := Migrations(config.DriverSQLite)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), total, "every migration must be applied exactly once")
+}
+
+func TestMigratorForwardOnly(t *testing.T) {
+	sqlDB := newTestSQLite(t)
+	migrator := &Migrator{
+		db: sqlDB,
+		migrations: []Migration{
+			{Version: 2, Name: "second", Up: `CREATE TABLE second (id integer)`},
+		},
+	}
+	_, err := migrator.Up()
+	if err != nil {
+		panic(err)
+	}
+
+	migrator.migrations = []Migration{
+		{Version: 1, Name: "first", Up: `CREATE TABLE first (id integer)`},
+		{Version: 2, Name: "second", Up: `CREATE TABLE second (id integer)`},
+		{Version: 3, Name: "third", Up: `CREATE TABLE third (id integer)`},
+	}
+	applied, err := migrator.Up()
+	assert.ErrorIs(t, err, MigrationOrderError)
+	assert.Empty(t, applied)
+}
+
+func TestMigratorFailedMigrationIsNotRecorded(t *testing.T) {
+	sqlDB := newTestSQLite(t)
+	migrator := &Migrator{
+		db: sqlDB,
+		migrations: []Migration{
+			{Version: 1, Name: "broken", Up: `CREATE TABLE broken (id integer); NOT SQL`},
+		},
+	}
+
+	_, err := migrator.Up()
+	assert.Error(t, err)
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	assert.False(t, statuses[0].Applied)
+}
+
+func TestMigratorSeed(t *testing.T) {
+	sqlDB := newTestSQLite(t)
+	migrator, err := NewMigrator(sqlDB, config.DriverSQLite)
+	if err != nil {
+		panic(err)
+	}
+	_, err = migrator.Up()
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NoError(t, migrator.Seed(fixtures.DevUsers))
+	assert.NoError(t, migrator.Seed(fixtures.DevUsers), "seeding twice must not fail")
+	var users int
+	err = sqlDB.QueryRow(`SELECT count(*) FROM users`).Scan(&users)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, users)
+	var role string
+	err = sqlDB.QueryRow(`SELECT role FROM users WHERE email = 'admin@bridge.test'`).Scan(&role)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", role)
+}
@@ -0,0 +1,58 @@
+package events
+
+import "sync"
+
+// SubscriberBuffer is how many events a slow subscriber may lag behind
+// before new events are dropped for it
+const SubscriberBuffer = 64
+
+// Bus delivers events published on a topic to every subscriber of that topic
+// within the process. Publishing never blocks on a slow subscriber.
+type Bus[T any] struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan T]struct{}
+}
+
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{
+		subscribers: make(map[string]map[chan T]struct{}),
+	}
+}
+
+func (b *Bus[T]) Publish(topic string, event T) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events of the topic and a func to stop receiving them,
+// which closes the channel
+func (b *Bus[T]) Subscribe(topic string) (<-chan T, func()) {
+	ch := make(chan T, SubscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan T]struct{})
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
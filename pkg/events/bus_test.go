@@ -0,0 +1,76 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus[int]()
+
+	first, cancelFirst := bus.Subscribe("a")
+	second, cancelSecond := bus.Subscribe("a")
+	other, cancelOther := bus.Subscribe("b")
+	defer cancelSecond()
+	defer cancelOther()
+
+	bus.Publish("a", 1)
+	assert.Equal(t, 1, <-first)
+	assert.Equal(t, 1, <-second)
+	assert.Empty(t, other)
+
+	cancelFirst()
+	cancelFirst()
+	_, ok := <-first
+	assert.False(t, ok)
+
+	bus.Publish("a", 2)
+	assert.Equal(t, 2, <-second)
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus[int]()
+	events, cancel := bus.Subscribe("a")
+	defer cancel()
+
+	for i := 0; i < SubscriberBuffer+1; i++ {
+		bus.Publish("a", i)
+	}
+	assert.Len(t, events, SubscriberBuffer)
+	assert.Equal(t, 0, <-events)
+}
+
+func TestRoomBusReplay(t *testing.T) {
+	bus := NewRoomBus()
+	bus.Publish(core.RoomEvent{Type: core.EventRoomCreated, RoomId: "a"})
+	bus.Publish(core.RoomEvent{Type: core.EventUserJoined, RoomId: "a"})
+
+	fresh, cancelFresh := bus.Subscribe(0)
+	defer cancelFresh()
+	resumed, cancelResumed := bus.Subscribe(1)
+	defer cancelResumed()
+
+	bus.Publish(core.RoomEvent{Type: core.EventRoomClosed, RoomId: "a"})
+
+	event := <-fresh
+	assert.Equal(t, int64(3), event.Id)
+	assert.Equal(t, core.EventRoomClosed, event.Type)
+
+	event = <-resumed
+	assert.Equal(t, int64(2), event.Id)
+	assert.Equal(t, core.EventUserJoined, event.Type)
+	event = <-resumed
+	assert.Equal(t, int64(3), event.Id)
+}
+
+func TestRoomBusHistorySize(t *testing.T) {
+	bus := NewRoomBus()
+	for i := 0; i < RoomHistorySize+10; i++ {
+		bus.Publish(core.RoomEvent{Type: core.EventUserJoined})
+	}
+	assert.Len(t, bus.history, RoomHistorySize)
+	assert.Equal(t, int64(11), bus.history[0].Id)
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+// RoomHistorySize is how many past room events are kept for reconnecting clients
+const RoomHistorySize = 256
+
+const roomTopic = "rooms"
+
+// RoomBus numbers room events and remembers the latest of them,
+// so a client that reconnects gets what it missed
+type RoomBus struct {
+	mu      sync.Mutex
+	bus     *Bus[core.RoomEvent]
+	lastId  int64
+	history []core.RoomEvent
+}
+
+func NewRoomBus() *RoomBus {
+	return &RoomBus{
+		bus:     NewBus[core.RoomEvent](),
+		history: make([]core.RoomEvent, 0, RoomHistorySize),
+	}
+}
+
+func (rb *RoomBus) Publish(event core.RoomEvent) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.lastId++
+	event.Id = rb.lastId
+	if len(rb.history) == RoomHistorySize {
+		rb.history = append(rb.history[:0], rb.history[1:]...)
+	}
+	rb.history = append(rb.history, event)
+	rb.bus.Publish(roomTopic, event)
+}
+
+// Subscribe replays the remembered events after last_event_id, 0 means none,
+// and then streams the new ones
+func (rb *RoomBus) Subscribe(last_event_id int64) (<-chan core.RoomEvent, func()) {
+	rb.mu.Lock()
+	events, cancelBus := rb.bus.Subscribe(roomTopic)
+	var replay []core.RoomEvent
+	if last_event_id > 0 {
+		for _, event := range rb.history {
+			if event.Id > last_event_id {
+				replay = append(replay, event)
+			}
+		}
+	}
+	rb.mu.Unlock()
+
+	out := make(chan core.RoomEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		for _, event := range replay {
+			select {
+			case out <- event:
+			case <-done:
+				return
+			}
+		}
+		for event := range events {
+			select {
+			case out <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			cancelBus()
+		})
+	}
+	return out, cancel
+}
@@ -0,0 +1,8 @@
+package events
+
+import "github.com/mrbttf/bridge-server/pkg/core"
+
+// NewSessionBus carries events of game sessions, a topic is a session id
+func NewSessionBus() *Bus[core.SessionEvent] {
+	return NewBus[core.SessionEvent]()
+}
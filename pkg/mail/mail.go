@@ -0,0 +1,100 @@
+// Package mail sends the emails of the server, through an SMTP server
+// or, for local development, into files or the log.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/log"
+)
+
+var HeaderInvalidError = errors.New("Email header contains a line break")
+
+// SMTPMailer sends through an SMTP server, authenticating if username is set
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg, err := message(m.from, to, subject, body)
+	if err != nil {
+		return fmt.Errorf("Unable to send email to %s: %w", to, err)
+	}
+	err = smtp.SendMail(m.addr, m.auth, m.from, []string{to}, msg)
+	if err != nil {
+		return fmt.Errorf("Unable to send email to %s: %w", to, err)
+	}
+	return nil
+}
+
+// FileMailer writes every email into a file in dir or, if dir is empty, into the log
+type FileMailer struct {
+	dir  string
+	from string
+	now  func() time.Time
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{
+		dir:  dir,
+		from: from,
+		now:  time.Now,
+	}
+}
+
+func (m *FileMailer) Send(to, subject, body string) error {
+	msg, err := message(m.from, to, subject, body)
+	if err != nil {
+		return fmt.Errorf("Unable to send email to %s: %w", to, err)
+	}
+	if m.dir == "" {
+		log.Info("Email not sent:\n", string(msg))
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s.eml", m.now().UTC().Format("20060102T150405.000000000"), to)
+	err = os.WriteFile(filepath.Join(m.dir, filepath.Base(name)), msg, 0o644)
+	if err != nil {
+		return fmt.Errorf("Unable to send email to %s: %w", to, err)
+	}
+	return nil
+}
+
+// message formats a plain text email, the subject encoded for non-ASCII characters
+func message(from, to, subject, body string) ([]byte, error) {
+	for _, header := range []string{from, to, subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, HeaderInvalidError
+		}
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limit lets through Requests per Window, counted in fixed windows
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Lockout locks a key once it has failed Threshold times within Memory,
+// for Base at first and twice as long with every further failure, up to Max
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Memory    time.Duration
+}
+
+// Duration is how long the key is locked after failures
+func (lo Lockout) Duration(failures int) time.Duration {
+	if failures < lo.Threshold {
+		return 0
+	}
+	d := lo.Base
+	for i := lo.Threshold; i < failures && d < lo.Max; i++ {
+		d *= 2
+	}
+	if d > lo.Max {
+		return lo.Max
+	}
+	return d
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Allow counts a request for key and tells if it is within the limit,
+// if not, the request may be retried after the returned duration
+func (l *Limiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	count, reset, err := l.store.Incr("limit:"+key, limit.Window)
+	if err != nil {
+		return false, 0, fmt.Errorf("Unable to limit %s: %w", key, err)
+	}
+	if count <= limit.Requests {
+		return true, 0, nil
+	}
+	return false, l.until(reset), nil
+}
+
+// Locked returns how long key stays locked, zero if it is not
+func (l *Limiter) Locked(key string) (time.Duration, error) {
+	count, reset, err := l.store.Get("lock:" + key)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to check lock of %s: %w", key, err)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return l.until(reset), nil
+}
+
+// Fail counts a failure for key and locks it as lockout says,
+// it returns how long the key is locked for
+func (l *Limiter) Fail(key string, lockout Lockout) (time.Duration, error) {
+	failures, _, err := l.store.Incr("failures:"+key, lockout.Memory)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to count failure of %s: %w", key, err)
+	}
+	d := lockout.Duration(failures)
+	if d == 0 {
+		return 0, nil
+	}
+	_, reset, err := l.store.Incr("lock:"+key, d)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to lock %s: %w", key, err)
+	}
+	return l.until(reset), nil
+}
+
+// Succeed forgets the failures of key
+func (l *Limiter) Succeed(key string) error {
+	err := l.store.Delete("failures:" + key)
+	if err != nil {
+		return fmt.Errorf("Unable to reset failures of %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *Limiter) until(reset time.Time) time.Duration {
+	d := reset.Sub(l.now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLimiter returns a limiter on a memory store
+// with a clock the test moves forward
+func newTestLimiter() (*Limiter, *MemoryStore, *time.Time) {
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store := NewMemoryStore()
+	store.now = clock
+	limiter := New(store)
+	limiter.now = clock
+	return limiter, store, &now
+}
+
+func TestLimiterAllow(t *testing.T) {
+	limiter, _, now := newTestLimiter()
+	limit := Limit{Requests: 2, Window: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := limiter.Allow("ip", limit)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	*now = now.Add(20 * time.Second)
+	ok, retryAfter, err := limiter.Allow("ip", limit)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 40*time.Second, retryAfter)
+	ok, _, _ = limiter.Allow("other ip", limit)
+	assert.True(t, ok, "keys must be limited separately")
+
+	*now = now.Add(40 * time.Second)
+	ok, _, err = limiter.Allow("ip", limit)
+	assert.NoError(t, err)
+	assert.True(t, ok, "the next window must start over")
+}
+
+func TestLockoutDuration(t *testing.T) {
+	lockout := Lockout{Threshold: 3, Base: time.Minute, Max: 10 * time.Minute}
+
+	assert.Equal(t, time.Duration(0), lockout.Duration(2))
+	assert.Equal(t, time.Minute, lockout.Duration(3))
+	assert.Equal(t, 2*time.Minute, lockout.Duration(4))
+	assert.Equal(t, 8*time.Minute, lockout.Duration(6))
+	assert.Equal(t, 10*time.Minute, lockout.Duration(7))
+	assert.Equal(t, 10*time.Minute, lockout.Duration(1000))
+}
+
+func TestLimiterLockout(t *testing.T) {
+	limiter, _, now := newTestLimiter()
+	lockout := Lockout{Threshold: 2, Base: time.Minute, Max: time.Hour, Memory: 24 * time.Hour}
+
+	locked, err := limiter.Fail("email", lockout)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), locked)
+	locked, err = limiter.Fail("email", lockout)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, locked)
+
+	*now = now.Add(30 * time.Second)
+	locked, err = limiter.Locked("email")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, locked)
+
+	*now = now.Add(30 * time.Second)
+	locked, _ = limiter.Locked("email")
+	assert.Equal(t, time.Duration(0), locked)
+	locked, _ = limiter.Fail("email", lockout)
+	assert.Equal(t, 2*time.Minute, locked, "every further failure must double the lockout")
+
+	*now = now.Add(2 * time.Minute)
+	assert.NoError(t, limiter.Succeed("email"))
+	locked, _ = limiter.Fail("email", lockout)
+	assert.Equal(t, time.Duration(0), locked, "a success must forget the failures")
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	_, store, now := newTestLimiter()
+
+	store.Incr("short", time.Second)
+	store.Incr("long", time.Hour)
+	*now = now.Add(sweepInterval)
+	store.Incr("new", time.Second)
+	assert.Len(t, store.counters, 2, "expired counters must be dropped")
+}
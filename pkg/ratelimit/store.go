@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps counters that expire, shared by every instance of the server
+// that uses the same store
+type Store interface {
+	// Incr adds one to the counter of key, which expires ttl after it was created,
+	// and returns the count and when the counter expires
+	Incr(key string, ttl time.Duration) (int, time.Time, error)
+	// Get returns the count and expiry of key, a zero count if there is no counter
+	Get(key string) (int, time.Time, error)
+	Delete(key string) error
+}
+
+// sweepInterval is how often MemoryStore drops the counters that have expired
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the counters of a single instance of the server
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]counter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type counter struct {
+	count   int
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]counter),
+		now:      time.Now,
+	}
+}
+
+func (ms *MemoryStore) Incr(key string, ttl time.Duration) (int, time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	ms.sweep(now)
+	c, ok := ms.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = counter{expires: now.Add(ttl)}
+	}
+	c.count++
+	ms.counters[key] = c
+	return c.count, c.expires, nil
+}
+
+func (ms *MemoryStore) Get(key string) (int, time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.counters[key]
+	if !ok || !ms.now().Before(c.expires) {
+		return 0, time.Time{}, nil
+	}
+	return c.count, c.expires, nil
+}
+
+func (ms *MemoryStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.counters, key)
+	return nil
+}
+
+func (ms *MemoryStore) sweep(now time.Time) {
+	if now.Sub(ms.lastSweep) < sweepInterval {
+		return
+	}
+	ms.lastSweep = now
+	for key, c := range ms.counters {
+		if !now.Before(c.expires) {
+			delete(ms.counters, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type MatchRepository struct {
+	db dbtx
+}
+
+func NewMatchRepository(db *sql.DB) *MatchRepository {
+	return &MatchRepository{db: db}
+}
+
+const SelectMatch = `
+SELECT match_id, room_id, players, score_limit, rounds, finished
+FROM matches
+WHERE match_id = $1
+`
+
+func (mr *MatchRepository) Get(match_id string) (core.Match, error) {
+	var match core.Match
+	var rounds []byte
+	err := mr.db.QueryRow(SelectMatch, match_id).Scan(
+		&match.Id,
+		&match.RoomId,
+		pq.Array(&match.Players),
+		&match.Limit,
+		&rounds,
+		&match.Finished,
+	)
+	if err != nil {
+		return core.Match{}, fmt.Errorf("Unable to get match for id %s: %w", match_id, err)
+	}
+	err = json.Unmarshal(rounds, &match.Rounds)
+	if err != nil {
+		return core.Match{}, fmt.Errorf("Unable to get match for id %s: %w", match_id, err)
+	}
+
+	return match, nil
+}
+
+const UpsertMatch = `
+INSERT INTO matches (match_id, room_id, players, score_limit, rounds, finished)
+VALUES($1, $2, $3, $4, $5, $6)
+ON CONFLICT (match_id)
+WHERE match_id = $1
+DO UPDATE
+SET
+	room_id = EXCLUDED.room_id,
+	players = EXCLUDED.players,
+	score_limit = EXCLUDED.score_limit,
+	rounds = EXCLUDED.rounds,
+	finished = EXCLUDED.finished
+`
+
+func (mr *MatchRepository) Store(match *core.Match) error {
+	rounds, err := json.Marshal(match.Rounds)
+	if err != nil {
+		return fmt.Errorf("Unable to store match for id %s: %w", match.Id, err)
+	}
+	_, err = mr.db.Exec(UpsertMatch,
+		match.Id,
+		match.RoomId,
+		pq.Array(match.Players),
+		match.Limit,
+		rounds,
+		match.Finished,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store match for id %s: %w", match.Id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type ActionTokenRepository struct {
+	conn conn
+}
+
+func (ar *ActionTokenRepository) Store(token *core.ActionToken) error {
+	t, unlock := ar.conn.lock()
+	defer unlock()
+
+	t.actionTokens[token.Id] = *token
+	return nil
+}
+
+func (ar *ActionTokenRepository) Consume(token_id string) (core.ActionToken, error) {
+	t, unlock := ar.conn.lock()
+	defer unlock()
+
+	token, ok := t.actionTokens[token_id]
+	if !ok {
+		return core.ActionToken{}, fmt.Errorf("Unable to consume action token %s: %w", token_id, NotFoundError)
+	}
+	delete(t.actionTokens, token_id)
+	return token, nil
+}
+
+func (ar *ActionTokenRepository) DeleteForUser(user_id string, purpose core.TokenPurpose) error {
+	t, unlock := ar.conn.lock()
+	defer unlock()
+
+	for id, token := range t.actionTokens {
+		if token.UserId == user_id && token.Purpose == purpose {
+			delete(t.actionTokens, id)
+		}
+	}
+	return nil
+}
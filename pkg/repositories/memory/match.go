@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type MatchRepository struct {
+	conn conn
+}
+
+func (mr *MatchRepository) Get(match_id string) (core.Match, error) {
+	t, unlock := mr.conn.lock()
+	defer unlock()
+
+	match, ok := t.matches[match_id]
+	if !ok {
+		return core.Match{}, fmt.Errorf("Unable to get match for id %s: %w", match_id, NotFoundError)
+	}
+	return cloneMatch(match), nil
+}
+
+func (mr *MatchRepository) Store(match *core.Match) error {
+	t, unlock := mr.conn.lock()
+	defer unlock()
+
+	t.matches[match.Id] = cloneMatch(*match)
+	return nil
+}
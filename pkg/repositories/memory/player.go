@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type PlayerRepository struct {
+	conn conn
+}
+
+func (pr *PlayerRepository) Get(player_id string) (core.Player, error) {
+	t, unlock := pr.conn.lock()
+	defer unlock()
+
+	player, ok := t.players[player_id]
+	if !ok {
+		return core.Player{}, fmt.Errorf("Unable to get player for id %s: %w", player_id, NotFoundError)
+	}
+	return clonePlayer(player), nil
+}
+
+func (pr *PlayerRepository) Store(player *core.Player) error {
+	t, unlock := pr.conn.lock()
+	defer unlock()
+
+	// A player of another session is a new row, as in the players table
+	if stored, ok := t.players[player.Id]; ok && stored.SessionId == player.SessionId && stored.Version != player.Version {
+		return fmt.Errorf("Unable to store player for id %s: %w", player.Id, core.VersionConflictError)
+	}
+	player.Version++
+	t.players[player.Id] = clonePlayer(*player)
+	return nil
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
+)
+
+type RoomRepository struct {
+	conn conn
+}
+
+func (rr *RoomRepository) Get(room_id string) (core.Room, error) {
+	t, unlock := rr.conn.lock()
+	defer unlock()
+
+	room, ok := t.rooms[room_id]
+	if !ok {
+		return core.Room{}, fmt.Errorf("Unable to get room for id %s: %w", room_id, NotFoundError)
+	}
+	return cloneRoom(room), nil
+}
+
+func (rr *RoomRepository) GetByUserId(user_id string) (string, error) {
+	t, unlock := rr.conn.lock()
+	defer unlock()
+
+	for _, room := range t.rooms {
+		if slices.Contains(room.Users, user_id) {
+			return room.Id, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to get room for user id %s: %w", user_id, core.NoRoomForUserError)
+}
+
+// List returns the rooms ordered by id, so pages of the lobby stay stable
+func (rr *RoomRepository) List(open bool) ([]core.Room, error) {
+	t, unlock := rr.conn.lock()
+	defer unlock()
+
+	var rooms []core.Room
+	for _, room := range t.rooms {
+		if room.Open == open {
+			rooms = append(rooms, cloneRoom(room))
+		}
+	}
+	slices.SortFunc(rooms, func(a, b core.Room) bool {
+		return a.Id < b.Id
+	})
+	return rooms, nil
+}
+
+func (rr *RoomRepository) Store(room *core.Room) error {
+	t, unlock := rr.conn.lock()
+	defer unlock()
+
+	t.rooms[room.Id] = cloneRoom(*room)
+	return nil
+}
+
+// Delete removes the matches played in the room along with it
+func (rr *RoomRepository) Delete(room_id string) error {
+	t, unlock := rr.conn.lock()
+	defer unlock()
+
+	delete(t.rooms, room_id)
+	for id, match := range t.matches {
+		if match.RoomId == room_id {
+			delete(t.matches, id)
+		}
+	}
+	return nil
+}
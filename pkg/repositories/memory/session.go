@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type SessionRepository struct {
+	conn conn
+}
+
+func (sr *SessionRepository) Get(session_id string) (core.Session, error) {
+	t, unlock := sr.conn.lock()
+	defer unlock()
+
+	session, ok := t.sessions[session_id]
+	if !ok {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, NotFoundError)
+	}
+	return cloneSession(session), nil
+}
+
+func (sr *SessionRepository) Store(session *core.Session) error {
+	t, unlock := sr.conn.lock()
+	defer unlock()
+
+	if stored, ok := t.sessions[session.Id]; ok && stored.Version != session.Version {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, core.VersionConflictError)
+	}
+	session.Version++
+	t.sessions[session.Id] = cloneSession(*session)
+	return nil
+}
+
+// Delete removes the players of the session along with it
+func (sr *SessionRepository) Delete(session_id string) error {
+	t, unlock := sr.conn.lock()
+	defer unlock()
+
+	delete(t.sessions, session_id)
+	for id, player := range t.players {
+		if player.SessionId == session_id {
+			delete(t.players, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Package memory keeps every aggregate in process memory, for running
+// the server without a database in local setups, demos and tests.
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+var NotFoundError = errors.New("Not found")
+
+type tables struct {
+	sessions     map[string]core.Session
+	players      map[string]core.Player
+	users        map[string]core.User
+	rooms        map[string]core.Room
+	matches      map[string]core.Match
+	tokens       map[string]core.Token
+	actionTokens map[string]core.ActionToken
+}
+
+func (t *tables) clone() *tables {
+	return &tables{
+		sessions:     maps.Clone(t.sessions),
+		players:      maps.Clone(t.players),
+		users:        maps.Clone(t.users),
+		rooms:        maps.Clone(t.rooms),
+		matches:      maps.Clone(t.matches),
+		tokens:       maps.Clone(t.tokens),
+		actionTokens: maps.Clone(t.actionTokens),
+	}
+}
+
+// Store holds the data of every repository. One lock guards it,
+// so units of work run one at a time as if they were serializable transactions.
+type Store struct {
+	mu sync.Mutex
+	t  *tables
+}
+
+func NewStore() *Store {
+	return &Store{
+		t: &tables{
+			sessions:     map[string]core.Session{},
+			players:      map[string]core.Player{},
+			users:        map[string]core.User{},
+			rooms:        map[string]core.Room{},
+			matches:      map[string]core.Match{},
+			tokens:       map[string]core.Token{},
+			actionTokens: map[string]core.ActionToken{},
+		},
+	}
+}
+
+// Repositories returns repositories that take the lock for every call
+func (s *Store) Repositories() core.Repositories {
+	return newRepositories(conn{store: s})
+}
+
+// Do runs fn holding the lock and puts every table back as it was if fn fails
+func (s *Store) Do(fn func(core.Repositories) error) error {
+	var committed core.Committed
+	err := s.do(func(repos core.Repositories) error {
+		repos.AfterCommit = committed.Add
+		return fn(repos)
+	})
+	if err != nil {
+		return err
+	}
+	committed.Run()
+	return nil
+}
+
+func (s *Store) do(fn func(core.Repositories) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.t.clone()
+	err := fn(newRepositories(conn{store: s, locked: true}))
+	if err != nil {
+		s.t = before
+	}
+	return err
+}
+
+func newRepositories(c conn) core.Repositories {
+	return core.Repositories{
+		Sessions:     &SessionRepository{conn: c},
+		Players:      &PlayerRepository{conn: c},
+		Users:        &UserRepository{conn: c},
+		Rooms:        &RoomRepository{conn: c},
+		Matches:      &MatchRepository{conn: c},
+		Tokens:       &TokenRepository{conn: c},
+		ActionTokens: &ActionTokenRepository{conn: c},
+	}
+}
+
+// conn is how a repository reaches the store: on its own or
+// from a unit of work that already holds the lock
+type conn struct {
+	store  *Store
+	locked bool
+}
+
+// lock returns the tables and the func that releases them
+func (c conn) lock() (*tables, func()) {
+	if c.locked {
+		return c.store.t, func() {}
+	}
+	c.store.mu.Lock()
+	return c.store.t, c.store.mu.Unlock
+}
+
+// Stored values share no memory with the ones callers get or pass,
+// services change slices of the aggregates in place
+
+func cloneSession(session core.Session) core.Session {
+	session.Players = slices.Clone(session.Players)
+	session.Deck = slices.Clone(session.Deck)
+	session.Table = slices.Clone(session.Table)
+	if session.DemandedSuit != nil {
+		suit := *session.DemandedSuit
+		session.DemandedSuit = &suit
+	}
+	session.Rules = cloneRules(session.Rules)
+	return session
+}
+
+func clonePlayer(player core.Player) core.Player {
+	player.Cards = slices.Clone(player.Cards)
+	return player
+}
+
+func cloneRoom(room core.Room) core.Room {
+	room.Users = slices.Clone(room.Users)
+	room.Settings.Rules = cloneRules(room.Settings.Rules)
+	return room
+}
+
+func cloneMatch(match core.Match) core.Match {
+	match.Players = slices.Clone(match.Players)
+	match.Rounds = slices.Clone(match.Rounds)
+	for i := range match.Rounds {
+		match.Rounds[i].Scores = maps.Clone(match.Rounds[i].Scores)
+	}
+	return match
+}
+
+func cloneRules(rs rules.RuleSet) rules.RuleSet {
+	rs.MustLayRanks = slices.Clone(rs.MustLayRanks)
+	rs.MustLayOrPullRanks = slices.Clone(rs.MustLayOrPullRanks)
+	rs.StackRanks = slices.Clone(rs.StackRanks)
+	rs.Effects = maps.Clone(rs.Effects)
+	return rs
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreUnitOfWorkRollback(t *testing.T) {
+	store := NewStore()
+	repos := store.Repositories()
+
+	failed := errors.New("failed")
+	committed := 0
+	err := store.Do(func(repos core.Repositories) error {
+		err := repos.Sessions.Store(&core.Session{Id: "session"})
+		if err != nil {
+			panic(err)
+		}
+		repos.AfterCommit(func() { committed++ })
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	_, err = repos.Sessions.Get("session")
+	assert.ErrorIs(t, err, NotFoundError)
+	assert.Zero(t, committed, "what waits for the commit is dropped with the rollback")
+
+	err = store.Do(func(repos core.Repositories) error {
+		repos.AfterCommit(func() { committed++ })
+		return repos.Sessions.Store(&core.Session{Id: "session"})
+	})
+	assert.NoError(t, err)
+	_, err = repos.Sessions.Get("session")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, committed)
+}
+
+func TestStoreVersionConflict(t *testing.T) {
+	repos := NewStore().Repositories()
+
+	session := &core.Session{Id: "session"}
+	err := repos.Sessions.Store(session)
+	if err != nil {
+		panic(err)
+	}
+	first, err := repos.Sessions.Get("session")
+	if err != nil {
+		panic(err)
+	}
+	second, err := repos.Sessions.Get("session")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NoError(t, repos.Sessions.Store(&first))
+	assert.ErrorIs(t, repos.Sessions.Store(&second), core.VersionConflictError)
+	assert.NoError(t, repos.Sessions.Store(&first))
+}
+
+func TestStoreCopiesValues(t *testing.T) {
+	repos := NewStore().Repositories()
+
+	cards := []core.Card{
+		core.NewCard(deck.Heart, deck.Six),
+		core.NewCard(deck.Heart, deck.Seven),
+	}
+	err := repos.Players.Store(&core.Player{Id: "player", Cards: cards})
+	if err != nil {
+		panic(err)
+	}
+	cards[0] = core.NewCard(deck.Spade, deck.Ace)
+
+	player, err := repos.Players.Get("player")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, core.NewCard(deck.Heart, deck.Six), player.Cards[0])
+
+	player.Cards = append(player.Cards[:0], player.Cards[1:]...)
+	stored, err := repos.Players.Get("player")
+	if err != nil {
+		panic(err)
+	}
+	assert.Len(t, stored.Cards, 2)
+	assert.Equal(t, core.NewCard(deck.Heart, deck.Six), stored.Cards[0])
+}
+
+func TestStoreDeleteSessionRemovesPlayers(t *testing.T) {
+	repos := NewStore().Repositories()
+
+	err := repos.Sessions.Store(&core.Session{Id: "session"})
+	if err != nil {
+		panic(err)
+	}
+	err = repos.Players.Store(&core.Player{Id: "player", SessionId: "session"})
+	if err != nil {
+		panic(err)
+	}
+
+	err = repos.Sessions.Delete("session")
+	assert.NoError(t, err)
+	_, err = repos.Players.Get("player")
+	assert.ErrorIs(t, err, NotFoundError)
+}
+
+func TestStoreConcurrentUnitsOfWork(t *testing.T) {
+	store := NewStore()
+	repos := store.Repositories()
+	err := repos.Matches.Store(&core.Match{Id: "match"})
+	if err != nil {
+		panic(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Do(func(repos core.Repositories) error {
+				match, err := repos.Matches.Get("match")
+				if err != nil {
+					return err
+				}
+				match.Limit++
+				return repos.Matches.Store(&match)
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	match, err := repos.Matches.Get("match")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 50, match.Limit)
+}
+
+func TestStoreUniqueUsers(t *testing.T) {
+	repos := NewStore().Repositories()
+	user := core.User{Id: "user", Email: "user@bridge.test", Nickname: "User"}
+	assert.NoError(t, repos.Users.Store(&user))
+	user.Password = "changed"
+	assert.NoError(t, repos.Users.Store(&user), "a user must be able to update itself")
+
+	err := repos.Users.Store(&core.User{Id: "other", Email: "USER@bridge.test", Nickname: "Other"})
+	assert.ErrorIs(t, err, core.UserExistsError)
+	err = repos.Users.Store(&core.User{Id: "other", Email: "other@bridge.test", Nickname: "user"})
+	assert.ErrorIs(t, err, core.UserExistsError)
+	assert.NoError(t, repos.Users.Store(&core.User{Id: "first"}))
+	assert.NoError(t, repos.Users.Store(&core.User{Id: "second"}), "empty emails and nicknames are not unique")
+
+	got, err := repos.Users.GetByEmail("User@Bridge.Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", got.Id)
+	got, err = repos.Users.GetByNickname("USER")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", got.Id)
+}
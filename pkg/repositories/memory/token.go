@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"golang.org/x/exp/slices"
+)
+
+type TokenRepository struct {
+	conn conn
+}
+
+func (tr *TokenRepository) GetByHash(hash string) (core.Token, error) {
+	t, unlock := tr.conn.lock()
+	defer unlock()
+
+	for _, token := range t.tokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return core.Token{}, fmt.Errorf("Unable to get token by hash: %w", NotFoundError)
+}
+
+func (tr *TokenRepository) ListForUser(user_id string) ([]core.Token, error) {
+	t, unlock := tr.conn.lock()
+	defer unlock()
+
+	var tokens []core.Token
+	for _, token := range t.tokens {
+		if token.UserId == user_id {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b core.Token) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (tr *TokenRepository) Store(token *core.Token) error {
+	t, unlock := tr.conn.lock()
+	defer unlock()
+
+	t.tokens[token.Id] = *token
+	return nil
+}
+
+func (tr *TokenRepository) Delete(token_id string) error {
+	t, unlock := tr.conn.lock()
+	defer unlock()
+
+	delete(t.tokens, token_id)
+	return nil
+}
+
+func (tr *TokenRepository) DeleteForUser(user_id string) error {
+	t, unlock := tr.conn.lock()
+	defer unlock()
+
+	for id, token := range t.tokens {
+		if token.UserId == user_id {
+			delete(t.tokens, id)
+		}
+	}
+	return nil
+}
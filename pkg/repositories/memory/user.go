@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type UserRepository struct {
+	conn conn
+}
+
+func (ur *UserRepository) Get(user_id string) (core.User, error) {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	user, ok := t.users[user_id]
+	if !ok {
+		return core.User{}, fmt.Errorf("Unable to get user for id %s: %w", user_id, NotFoundError)
+	}
+	return user, nil
+}
+
+func (ur *UserRepository) GetByEmail(email string) (core.User, error) {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	for _, user := range t.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return core.User{}, fmt.Errorf("Unable to get user by email %s: %w", email, NotFoundError)
+}
+
+func (ur *UserRepository) GetByNickname(nickname string) (core.User, error) {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	for _, user := range t.users {
+		if strings.EqualFold(user.Nickname, nickname) {
+			return user, nil
+		}
+	}
+	return core.User{}, fmt.Errorf("Unable to get user by nickname %s: %w", nickname, NotFoundError)
+}
+
+func (ur *UserRepository) GetForRoom(room_id string) ([]core.User, error) {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	room, ok := t.rooms[room_id]
+	if !ok {
+		return nil, nil
+	}
+	var users []core.User
+	for _, user_id := range room.Users {
+		if user, ok := t.users[user_id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (ur *UserRepository) ListGuests() ([]core.User, error) {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	var users []core.User
+	for _, user := range t.users {
+		if user.Guest {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (ur *UserRepository) Store(user *core.User) error {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	for _, other := range t.users {
+		if other.Id != user.Id && (taken(other.Email, user.Email) || taken(other.Nickname, user.Nickname)) {
+			return fmt.Errorf("Unable to store user for id %s: %w", user.Id, core.UserExistsError)
+		}
+	}
+	t.users[user.Id] = *user
+	return nil
+}
+
+// Delete removes the user and cascades as the foreign keys of the databases do
+func (ur *UserRepository) Delete(user_id string) error {
+	t, unlock := ur.conn.lock()
+	defer unlock()
+
+	delete(t.users, user_id)
+	delete(t.players, user_id)
+	for id, token := range t.tokens {
+		if token.UserId == user_id {
+			delete(t.tokens, id)
+		}
+	}
+	for id, token := range t.actionTokens {
+		if token.UserId == user_id {
+			delete(t.actionTokens, id)
+		}
+	}
+	for id, session := range t.sessions {
+		if session.CurrentPlayer == user_id {
+			delete(t.sessions, id)
+		}
+	}
+	for id, room := range t.rooms {
+		if room.Host != user_id {
+			continue
+		}
+		delete(t.rooms, id)
+		for match_id, match := range t.matches {
+			if match.RoomId == id {
+				delete(t.matches, match_id)
+			}
+		}
+	}
+	return nil
+}
+
+// taken tells if a unique value is in use, empty ones are not unique
+// just as the unique indexes of the databases leave them out
+func taken(existing, value string) bool {
+	return value != "" && strings.EqualFold(existing, value)
+}
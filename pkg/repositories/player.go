@@ -9,7 +9,7 @@ import (
 )
 
 type PlayerRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewPlayerRepository(db *sql.DB) *PlayerRepository {
@@ -17,7 +17,7 @@ func NewPlayerRepository(db *sql.DB) *PlayerRepository {
 }
 
 const SelectPlayer = `
-SELECT user_id, nickname, cards, state, session_id
+SELECT user_id, nickname, cards, state, session_id, version
 FROM players
 WHERE user_id = $1
 `
@@ -31,6 +31,7 @@ func (pp *PlayerRepository) Get(player_id string) (core.Player, error) {
 		pq.Array(&cards),
 		&player.State,
 		&player.SessionId,
+		&player.Version,
 	)
 	player.Cards = StringToDeck(cards)
 	if err != nil {
@@ -40,9 +41,11 @@ func (pp *PlayerRepository) Get(player_id string) (core.Player, error) {
 	return player, nil
 }
 
+// UpsertPlayer bumps the version and updates the row only
+// if it still has the version the player was read at
 const UpsertPlayer = `
-INSERT INTO players (user_id, nickname, cards, state, state_name, session_id)
-VALUES($1, $2, $3, $4, $5, $6) 
+INSERT INTO players (user_id, nickname, cards, state, state_name, session_id, version)
+VALUES($1, $2, $3, $4, $5, $6, $7) 
 ON CONFLICT (user_id, session_id) 
 WHERE user_id = $1 AND session_id = $6  
 DO UPDATE
@@ -52,18 +55,25 @@ SET
 	cards = EXCLUDED.cards, 
 	state = EXCLUDED.state, 
 	state_name = EXCLUDED.state_name, 
-	session_id = EXCLUDED.session_id
+	session_id = EXCLUDED.session_id, 
+	version = EXCLUDED.version
+WHERE players.version = EXCLUDED.version - 1
 `
 
 func (pp *PlayerRepository) Store(player *core.Player) error {
 	cards := DeckToString(player.Cards)
-	_, err := pp.db.Exec(UpsertPlayer,
+	result, err := pp.db.Exec(UpsertPlayer,
 		player.Id, player.Nickname, pq.Array(cards),
-		player.State, player.State.String(), player.SessionId,
+		player.State, player.State.String(), player.SessionId, player.Version+1,
 	)
 	if err != nil {
 		return err
 	}
+	err = checkVersion(result)
+	if err != nil {
+		return fmt.Errorf("Unable to store player for id %s: %w", player.Id, err)
+	}
+	player.Version++
 
 	return nil
 }
@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"fmt"
+
 	"github.com/MrBTTF/gophercises/deck"
 	"github.com/mrbttf/bridge-server/pkg/core"
 	"golang.org/x/exp/slices"
@@ -32,6 +34,30 @@ func StringToCard(card string) deck.Card {
 	return core.NewCard(deck.Suit(suit_idx), deck.Rank(rank_idx))
 }
 
+func SuitToString(suit deck.Suit) string {
+	return suits[suit]
+}
+
+func StringToSuit(suit string) (deck.Suit, error) {
+	suit_idx := slices.Index(suits, suit)
+	if suit_idx == -1 {
+		return 0, fmt.Errorf("Unknown suit %s", suit)
+	}
+	return deck.Suit(suit_idx), nil
+}
+
+func RankToString(rank deck.Rank) string {
+	return ranks[rank]
+}
+
+func StringToRank(rank string) (deck.Rank, error) {
+	rank_idx := slices.Index(ranks, rank)
+	if rank_idx < 1 {
+		return 0, fmt.Errorf("Unknown rank %s", rank)
+	}
+	return deck.Rank(rank_idx), nil
+}
+
 func StringToDeck(_deck []string) []deck.Card {
 	result := make([]deck.Card, 0, len(_deck))
 	for _, card := range _deck {
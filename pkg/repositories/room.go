@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"github.com/lib/pq"
@@ -9,7 +10,7 @@ import (
 )
 
 type RoomRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewRoomRepository(db *sql.DB) *RoomRepository {
@@ -17,24 +18,45 @@ func NewRoomRepository(db *sql.DB) *RoomRepository {
 }
 
 const SelectRoomById = `
-SELECT room_id, host_id, user_ids, open
+SELECT room_id, host_id, user_ids, open, settings
 FROM rooms
 WHERE room_id = $1
 `
 
 func (rr *RoomRepository) Get(room_id string) (core.Room, error) {
-	var room core.Room
+	room, err := scanRoom(rr.db.QueryRow(SelectRoomById, room_id))
+	if err != nil {
+		return core.Room{}, fmt.Errorf("Unable to get room for id %s: %w", room_id, err)
+	}
+
+	return room, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
 
-	err := rr.db.QueryRow(SelectRoomById, room_id).Scan(
+func scanRoom(row scanner) (core.Room, error) {
+	var room core.Room
+	var settings []byte
+	err := row.Scan(
 		&room.Id,
 		&room.Host,
 		pq.Array(&room.Users),
 		&room.Open,
+		&settings,
 	)
 	if err != nil {
-		return core.Room{}, fmt.Errorf("Unable to get room for id %s: %w", room_id, err)
+		return core.Room{}, err
+	}
+	err = json.Unmarshal(settings, &room.Settings)
+	if err != nil {
+		return core.Room{}, err
+	}
+	if room.Settings.DeckSize == 0 {
+		// rooms stored before they had settings hold the column default '{}'
+		room.Settings = core.DefaultRoomSettings()
 	}
-
 	return room, nil
 }
 
@@ -61,7 +83,7 @@ func (rr *RoomRepository) GetByUserId(user_id string) (string, error) {
 }
 
 const SelectRooms = `
-SELECT room_id, host_id, user_ids, open
+SELECT room_id, host_id, user_ids, open, settings
 FROM rooms
 WHERE open = $1
 `
@@ -75,13 +97,8 @@ func (rr *RoomRepository) List(open bool) ([]core.Room, error) {
 
 	var rooms []core.Room
 	for rows.Next() {
-		var room core.Room
-		if err := rows.Scan(
-			&room.Id,
-			&room.Host,
-			pq.Array(&room.Users),
-			&room.Open,
-		); err != nil {
+		room, err := scanRoom(rows)
+		if err != nil {
 			return nil, fmt.Errorf("Unable to list rooms for open %t: %w", open, err)
 		}
 		rooms = append(rooms, room)
@@ -94,23 +111,29 @@ func (rr *RoomRepository) List(open bool) ([]core.Room, error) {
 }
 
 const UpsertRoom = `
-INSERT INTO rooms (room_id, host_id, user_ids, open)
-VALUES($1, $2, $3, $4) 
+INSERT INTO rooms (room_id, host_id, user_ids, open, settings)
+VALUES($1, $2, $3, $4, $5) 
 ON CONFLICT (room_id) 
 WHERE room_id = $1 
 DO UPDATE
 SET 
 	host_id = EXCLUDED.host_id, 
 	user_ids = EXCLUDED.user_ids, 
-	open = EXCLUDED.open 
+	open = EXCLUDED.open, 
+	settings = EXCLUDED.settings
 `
 
 func (rr *RoomRepository) Store(room *core.Room) error {
-	_, err := rr.db.Exec(UpsertRoom,
+	settings, err := json.Marshal(room.Settings)
+	if err != nil {
+		return fmt.Errorf("Unable to store room for id %s: %w", room.Id, err)
+	}
+	_, err = rr.db.Exec(UpsertRoom,
 		room.Id,
 		room.Host,
 		pq.Array(room.Users),
 		room.Open,
+		settings,
 	)
 	if err != nil {
 		return err
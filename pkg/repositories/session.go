@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"github.com/lib/pq"
@@ -9,7 +10,7 @@ import (
 )
 
 type SessionRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewSessionRepository(db *sql.DB) *SessionRepository {
@@ -17,7 +18,7 @@ func NewSessionRepository(db *sql.DB) *SessionRepository {
 }
 
 const SelectSession = `
-SELECT session_id, players, deck, session_table, current_player
+SELECT session_id, players, deck, session_table, current_player, winner, reshuffles, demanded_suit, direction, rules, pending, bridge, version
 FROM sessions
 WHERE session_id = $1
 `
@@ -26,15 +27,37 @@ func (sp *SessionRepository) Get(session_id string) (core.Session, error) {
 	var session core.Session
 	var _deck []string
 	var table []string
+	var demandedSuit sql.NullInt16
+	var rules, pending []byte
 	err := sp.db.QueryRow(SelectSession, session_id).Scan(
 		&session.Id,
 		pq.Array(&session.Players),
 		pq.Array(&_deck),
 		pq.Array(&table),
 		&session.CurrentPlayer,
+		&session.Winner,
+		&session.Reshuffles,
+		&demandedSuit,
+		&session.Direction,
+		&rules,
+		&pending,
+		&session.Bridge,
+		&session.Version,
 	)
 	session.Deck = StringToDeck(_deck)
 	session.Table = StringToDeck(table)
+	if demandedSuit.Valid {
+		suit := core.Suit(demandedSuit.Int16)
+		session.DemandedSuit = &suit
+	}
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
+	}
+	err = json.Unmarshal(rules, &session.Rules)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
+	}
+	err = json.Unmarshal(pending, &session.Pending)
 	if err != nil {
 		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
 	}
@@ -42,9 +65,11 @@ func (sp *SessionRepository) Get(session_id string) (core.Session, error) {
 	return session, nil
 }
 
+// UpsertSession bumps the version and updates the row only
+// if it still has the version the session was read at
 const UpsertSession = `
-INSERT INTO sessions (session_id, players, deck, session_table, current_player)
-VALUES($1, $2, $3, $4, $5) 
+INSERT INTO sessions (session_id, players, deck, session_table, current_player, winner, reshuffles, demanded_suit, direction, rules, pending, bridge, version)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) 
 ON CONFLICT (session_id) 
 WHERE session_id = $1 
 DO UPDATE
@@ -52,20 +77,47 @@ SET
 players = EXCLUDED.players, 
 deck = EXCLUDED.deck, 
 session_table = EXCLUDED.session_table, 
-current_player = EXCLUDED.current_player
+current_player = EXCLUDED.current_player, 
+winner = EXCLUDED.winner, 
+reshuffles = EXCLUDED.reshuffles, 
+demanded_suit = EXCLUDED.demanded_suit, 
+direction = EXCLUDED.direction, 
+rules = EXCLUDED.rules, 
+pending = EXCLUDED.pending, 
+bridge = EXCLUDED.bridge, 
+version = EXCLUDED.version
+WHERE sessions.version = EXCLUDED.version - 1
 `
 
 func (sp *SessionRepository) Store(session *core.Session) error {
 	_deck := DeckToString(session.Deck)
 	table := DeckToString(session.Table)
-	_, err := sp.db.Exec(UpsertSession,
+	var demandedSuit sql.NullInt16
+	if session.DemandedSuit != nil {
+		demandedSuit = sql.NullInt16{Int16: int16(*session.DemandedSuit), Valid: true}
+	}
+	rules, err := json.Marshal(session.Rules)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	pending, err := json.Marshal(session.Pending)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	result, err := sp.db.Exec(UpsertSession,
 		session.Id, pq.Array(session.Players),
-		pq.Array(_deck), pq.Array(table), session.CurrentPlayer,
+		pq.Array(_deck), pq.Array(table), session.CurrentPlayer, session.Winner, session.Reshuffles,
+		demandedSuit, session.Direction, rules, pending, session.Bridge, session.Version+1,
 	)
 	if err != nil {
 		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
 
 	}
+	err = checkVersion(result)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	session.Version++
 
 	return nil
 }
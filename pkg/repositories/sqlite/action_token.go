@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type ActionTokenRepository struct {
+	db dbtx
+}
+
+func NewActionTokenRepository(db *sql.DB) *ActionTokenRepository {
+	return &ActionTokenRepository{db: db}
+}
+
+const InsertActionToken = `
+INSERT INTO action_tokens (token_id, user_id, purpose, expires_at)
+VALUES($1, $2, $3, $4)
+`
+
+func (ar *ActionTokenRepository) Store(token *core.ActionToken) error {
+	_, err := ar.db.Exec(InsertActionToken,
+		token.Id,
+		token.UserId,
+		token.Purpose,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store action token for user id %s: %w", token.UserId, err)
+	}
+	return nil
+}
+
+const DeleteActionTokenReturning = `
+DELETE FROM action_tokens
+WHERE token_id = $1
+RETURNING token_id, user_id, purpose, expires_at
+`
+
+func (ar *ActionTokenRepository) Consume(token_id string) (core.ActionToken, error) {
+	var token core.ActionToken
+	err := ar.db.QueryRow(DeleteActionTokenReturning, token_id).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Purpose,
+		&token.ExpiresAt,
+	)
+	if err != nil {
+		return core.ActionToken{}, fmt.Errorf("Unable to consume action token %s: %w", token_id, err)
+	}
+	return token, nil
+}
+
+const DeleteActionTokensForUser = `
+DELETE FROM action_tokens
+WHERE user_id = $1 AND purpose = $2
+`
+
+func (ar *ActionTokenRepository) DeleteForUser(user_id string, purpose core.TokenPurpose) error {
+	_, err := ar.db.Exec(DeleteActionTokensForUser, user_id, purpose)
+	if err != nil {
+		return fmt.Errorf("Unable to delete action tokens for user id %s: %w", user_id, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package sqlite
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// textArray keeps a list of strings in a text column as a JSON array,
+// it takes the place of pq.Array since SQLite has no array type
+type textArray []string
+
+func (a textArray) Value() (driver.Value, error) {
+	if a == nil {
+		return "[]", nil
+	}
+	value, err := json.Marshal([]string(a))
+	if err != nil {
+		return nil, err
+	}
+	return string(value), nil
+}
+
+func (a *textArray) Scan(src any) error {
+	var value []byte
+	switch src := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		value = []byte(src)
+	case []byte:
+		value = src
+	default:
+		return fmt.Errorf("Unable to scan %T into a text array", src)
+	}
+	var result []string
+	err := json.Unmarshal(value, &result)
+	if err != nil {
+		return err
+	}
+	*a = result
+	return nil
+}
@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories"
+)
+
+type PlayerRepository struct {
+	db dbtx
+}
+
+func NewPlayerRepository(db *sql.DB) *PlayerRepository {
+	return &PlayerRepository{db: db}
+}
+
+const SelectPlayer = `
+SELECT user_id, nickname, cards, state, session_id, version
+FROM players
+WHERE user_id = $1
+`
+
+func (pp *PlayerRepository) Get(player_id string) (core.Player, error) {
+	var player core.Player
+	var cards []string
+	err := pp.db.QueryRow(SelectPlayer, player_id).Scan(
+		&player.Id,
+		&player.Nickname,
+		(*textArray)(&cards),
+		&player.State,
+		&player.SessionId,
+		&player.Version,
+	)
+	if err != nil {
+		return core.Player{}, fmt.Errorf("Unable to get player for id %s: %w", player_id, err)
+	}
+	player.Cards = repositories.StringToDeck(cards)
+
+	return player, nil
+}
+
+// UpsertPlayer bumps the version and updates the row only
+// if it still has the version the player was read at
+const UpsertPlayer = `
+INSERT INTO players (user_id, nickname, cards, state, state_name, session_id, version)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id, session_id)
+DO UPDATE
+SET
+	nickname = excluded.nickname,
+	cards = excluded.cards,
+	state = excluded.state,
+	state_name = excluded.state_name,
+	version = excluded.version
+WHERE players.version = excluded.version - 1
+`
+
+func (pp *PlayerRepository) Store(player *core.Player) error {
+	cards := repositories.DeckToString(player.Cards)
+	result, err := pp.db.Exec(UpsertPlayer,
+		player.Id, player.Nickname, textArray(cards),
+		player.State, player.State.String(), player.SessionId, player.Version+1,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store player for id %s: %w", player.Id, err)
+	}
+	err = checkVersion(result)
+	if err != nil {
+		return fmt.Errorf("Unable to store player for id %s: %w", player.Id, err)
+	}
+	player.Version++
+
+	return nil
+}
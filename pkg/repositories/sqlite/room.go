@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type RoomRepository struct {
+	db dbtx
+}
+
+func NewRoomRepository(db *sql.DB) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+const SelectRoomById = `
+SELECT room_id, host_id, user_ids, open, settings
+FROM rooms
+WHERE room_id = $1
+`
+
+func (rr *RoomRepository) Get(room_id string) (core.Room, error) {
+	room, err := scanRoom(rr.db.QueryRow(SelectRoomById, room_id))
+	if err != nil {
+		return core.Room{}, fmt.Errorf("Unable to get room for id %s: %w", room_id, err)
+	}
+
+	return room, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRoom(row scanner) (core.Room, error) {
+	var room core.Room
+	var settings []byte
+	err := row.Scan(
+		&room.Id,
+		&room.Host,
+		(*textArray)(&room.Users),
+		&room.Open,
+		&settings,
+	)
+	if err != nil {
+		return core.Room{}, err
+	}
+	err = json.Unmarshal(settings, &room.Settings)
+	if err != nil {
+		return core.Room{}, err
+	}
+	if room.Settings.DeckSize == 0 {
+		// rooms stored before they had settings hold the column default '{}'
+		room.Settings = core.DefaultRoomSettings()
+	}
+	return room, nil
+}
+
+const SelectRoomByUserId = `
+SELECT room_id
+FROM rooms, json_each(rooms.user_ids) AS member
+WHERE member.value = $1
+`
+
+func (rr *RoomRepository) GetByUserId(user_id string) (string, error) {
+	var room_id string
+
+	err := rr.db.QueryRow(SelectRoomByUserId, user_id).Scan(
+		&room_id,
+	)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("Unable to get room for user id %s: %w", user_id, core.NoRoomForUserError)
+	} else if err != nil {
+		return "", fmt.Errorf("Unable to get room for user id %s: %w", user_id, err)
+	}
+
+	return room_id, nil
+}
+
+const SelectRooms = `
+SELECT room_id, host_id, user_ids, open, settings
+FROM rooms
+WHERE open = $1
+`
+
+func (rr *RoomRepository) List(open bool) ([]core.Room, error) {
+	rows, err := rr.db.Query(SelectRooms, open)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list rooms for open %t: %w", open, err)
+	}
+	defer rows.Close()
+
+	var rooms []core.Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list rooms for open %t: %w", open, err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list rooms for open %t: %w", open, err)
+	}
+	return rooms, nil
+}
+
+const UpsertRoom = `
+INSERT INTO rooms (room_id, host_id, user_ids, open, settings)
+VALUES($1, $2, $3, $4, $5)
+ON CONFLICT (room_id)
+DO UPDATE
+SET
+	host_id = excluded.host_id,
+	user_ids = excluded.user_ids,
+	open = excluded.open,
+	settings = excluded.settings
+`
+
+func (rr *RoomRepository) Store(room *core.Room) error {
+	settings, err := json.Marshal(room.Settings)
+	if err != nil {
+		return fmt.Errorf("Unable to store room for id %s: %w", room.Id, err)
+	}
+	_, err = rr.db.Exec(UpsertRoom,
+		room.Id,
+		room.Host,
+		textArray(room.Users),
+		room.Open,
+		string(settings),
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store room for id %s: %w", room.Id, err)
+	}
+
+	return nil
+}
+
+const DeleteRoom = `
+DELETE FROM rooms
+WHERE room_id = $1
+`
+
+func (rr *RoomRepository) Delete(room_id string) error {
+	_, err := rr.db.Exec(DeleteRoom, room_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete room for id %s: %w", room_id, err)
+	}
+
+	return nil
+}
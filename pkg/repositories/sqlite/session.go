@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/repositories"
+)
+
+type SessionRepository struct {
+	db dbtx
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+const SelectSession = `
+SELECT session_id, players, deck, session_table, current_player, winner, reshuffles, demanded_suit, direction, rules, pending, bridge, version
+FROM sessions
+WHERE session_id = $1
+`
+
+func (sp *SessionRepository) Get(session_id string) (core.Session, error) {
+	var session core.Session
+	var _deck []string
+	var table []string
+	var demandedSuit sql.NullInt16
+	var rules, pending []byte
+	err := sp.db.QueryRow(SelectSession, session_id).Scan(
+		&session.Id,
+		(*textArray)(&session.Players),
+		(*textArray)(&_deck),
+		(*textArray)(&table),
+		&session.CurrentPlayer,
+		&session.Winner,
+		&session.Reshuffles,
+		&demandedSuit,
+		&session.Direction,
+		&rules,
+		&pending,
+		&session.Bridge,
+		&session.Version,
+	)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
+	}
+	session.Deck = repositories.StringToDeck(_deck)
+	session.Table = repositories.StringToDeck(table)
+	if demandedSuit.Valid {
+		suit := core.Suit(demandedSuit.Int16)
+		session.DemandedSuit = &suit
+	}
+	err = json.Unmarshal(rules, &session.Rules)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
+	}
+	err = json.Unmarshal(pending, &session.Pending)
+	if err != nil {
+		return core.Session{}, fmt.Errorf("Unable to get session for id %s: %w", session_id, err)
+	}
+
+	return session, nil
+}
+
+// UpsertSession bumps the version and updates the row only
+// if it still has the version the session was read at
+const UpsertSession = `
+INSERT INTO sessions (session_id, players, deck, session_table, current_player, winner, reshuffles, demanded_suit, direction, rules, pending, bridge, version)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (session_id)
+DO UPDATE
+SET
+	players = excluded.players,
+	deck = excluded.deck,
+	session_table = excluded.session_table,
+	current_player = excluded.current_player,
+	winner = excluded.winner,
+	reshuffles = excluded.reshuffles,
+	demanded_suit = excluded.demanded_suit,
+	direction = excluded.direction,
+	rules = excluded.rules,
+	pending = excluded.pending,
+	bridge = excluded.bridge,
+	version = excluded.version
+WHERE sessions.version = excluded.version - 1
+`
+
+func (sp *SessionRepository) Store(session *core.Session) error {
+	_deck := repositories.DeckToString(session.Deck)
+	table := repositories.DeckToString(session.Table)
+	var demandedSuit sql.NullInt16
+	if session.DemandedSuit != nil {
+		demandedSuit = sql.NullInt16{Int16: int16(*session.DemandedSuit), Valid: true}
+	}
+	rules, err := json.Marshal(session.Rules)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	pending, err := json.Marshal(session.Pending)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	result, err := sp.db.Exec(UpsertSession,
+		session.Id, textArray(session.Players),
+		textArray(_deck), textArray(table), session.CurrentPlayer, session.Winner, session.Reshuffles,
+		demandedSuit, session.Direction, string(rules), string(pending), session.Bridge, session.Version+1,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	err = checkVersion(result)
+	if err != nil {
+		return fmt.Errorf("Unable to store session for id %s: %w", session.Id, err)
+	}
+	session.Version++
+
+	return nil
+}
+
+const DeleteSession = `
+DELETE FROM sessions
+WHERE session_id = $1
+`
+
+func (sp *SessionRepository) Delete(session_id string) error {
+	_, err := sp.db.Exec(DeleteSession, session_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete session for id %s: %w", session_id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/config"
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	sqlDB, err := db.NewSQLite(filepath.Join(t.TempDir(), "bridge.db"))
+	if err != nil {
+		panic(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	migrator, err := db.NewMigrator(sqlDB, config.DriverSQLite)
+	if err != nil {
+		panic(err)
+	}
+	_, err = migrator.Up()
+	if err != nil {
+		panic(err)
+	}
+	return sqlDB
+}
+
+func storeUsers(repos core.Repositories, user_ids ...string) {
+	for _, user_id := range user_ids {
+		err := repos.Users.Store(&core.User{Id: user_id, Email: user_id + "@bridge.test", Nickname: user_id})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "first", "second")
+
+	suit := core.Suit(deck.Heart)
+	session := core.Session{
+		Id:            "session",
+		Players:       []string{"first", "second"},
+		Deck:          []core.Card{core.NewCard(deck.Spade, deck.Ace), core.NewCard(deck.Club, deck.Six)},
+		Table:         []core.Card{core.NewCard(deck.Heart, deck.Ten)},
+		CurrentPlayer: "second",
+		DemandedSuit:  &suit,
+		Direction:     -1,
+		Bridge:        true,
+	}
+	err := repos.Sessions.Store(&session)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, session.Version)
+
+	got, err := repos.Sessions.Get("session")
+	assert.NoError(t, err)
+	assert.Equal(t, session, got)
+}
+
+func TestSessionVersionConflict(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "first")
+
+	err := repos.Sessions.Store(&core.Session{Id: "session", CurrentPlayer: "first"})
+	if err != nil {
+		panic(err)
+	}
+	first, err := repos.Sessions.Get("session")
+	if err != nil {
+		panic(err)
+	}
+	second, err := repos.Sessions.Get("session")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NoError(t, repos.Sessions.Store(&first))
+	assert.ErrorIs(t, repos.Sessions.Store(&second), core.VersionConflictError)
+	assert.NoError(t, repos.Sessions.Store(&first))
+}
+
+func TestPlayerCards(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "player")
+	err := repos.Sessions.Store(&core.Session{Id: "session", CurrentPlayer: "player"})
+	if err != nil {
+		panic(err)
+	}
+
+	player := core.Player{
+		Id:        "player",
+		Nickname:  "player",
+		Cards:     []core.Card{core.NewCard(deck.Heart, deck.Six), core.NewCard(deck.Diamond, deck.King)},
+		SessionId: "session",
+	}
+	assert.NoError(t, repos.Players.Store(&player))
+	player.Cards = player.Cards[1:]
+	assert.NoError(t, repos.Players.Store(&player))
+
+	got, err := repos.Players.Get("player")
+	assert.NoError(t, err)
+	assert.Equal(t, player, got)
+
+	err = repos.Sessions.Delete("session")
+	assert.NoError(t, err)
+	_, err = repos.Players.Get("player")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestRoomUsers(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "host", "guest", "late")
+
+	room := core.Room{
+		Id:       "room",
+		Host:     "host",
+		Users:    []string{"late", "host", "guest"},
+		Open:     true,
+		Settings: core.DefaultRoomSettings(),
+	}
+	assert.NoError(t, repos.Rooms.Store(&room))
+
+	got, err := repos.Rooms.Get("room")
+	assert.NoError(t, err)
+	assert.Equal(t, room, got)
+
+	users, err := repos.Users.GetForRoom("room")
+	assert.NoError(t, err)
+	var user_ids []string
+	for _, user := range users {
+		user_ids = append(user_ids, user.Id)
+	}
+	assert.Equal(t, room.Users, user_ids)
+
+	room_id, err := repos.Rooms.GetByUserId("guest")
+	assert.NoError(t, err)
+	assert.Equal(t, "room", room_id)
+	storeUsers(repos, "outsider")
+	_, err = repos.Rooms.GetByUserId("outsider")
+	assert.ErrorIs(t, err, core.NoRoomForUserError)
+
+	rooms, err := repos.Rooms.List(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []core.Room{room}, rooms)
+	rooms, err = repos.Rooms.List(false)
+	assert.NoError(t, err)
+	assert.Empty(t, rooms)
+}
+
+func TestRoomWithoutSettings(t *testing.T) {
+	sqlDB := newTestDB(t)
+	repos := NewRepositories(sqlDB)
+	storeUsers(repos, "host")
+	_, err := sqlDB.Exec(`INSERT INTO rooms (room_id, host_id, user_ids, open) VALUES ('room', 'host', '["host"]', true)`)
+	if err != nil {
+		panic(err)
+	}
+
+	room, err := repos.Rooms.Get("room")
+	assert.NoError(t, err)
+	assert.Equal(t, core.DefaultRoomSettings(), room.Settings, "a room stored before settings gets the defaults")
+}
+
+func TestUserRole(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "player")
+
+	user, err := repos.Users.Get("player")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Role(""), user.Role)
+	assert.False(t, user.IsAdmin())
+
+	user.Role = core.RoleAdmin
+	assert.NoError(t, repos.Users.Store(&user))
+	user, err = repos.Users.GetByEmail("player@bridge.test")
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin())
+}
+
+func TestUserUnique(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "user")
+
+	err := repos.Users.Store(&core.User{Id: "other", Email: "USER@bridge.test", Nickname: "other"})
+	assert.ErrorIs(t, err, core.UserExistsError)
+	err = repos.Users.Store(&core.User{Id: "other", Email: "other@bridge.test", Nickname: "User"})
+	assert.ErrorIs(t, err, core.UserExistsError)
+	assert.NoError(t, repos.Users.Store(&core.User{Id: "first"}))
+	assert.NoError(t, repos.Users.Store(&core.User{Id: "second"}), "empty emails and nicknames are not unique")
+
+	got, err := repos.Users.GetByEmail("User@Bridge.Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", got.Id)
+	got, err = repos.Users.GetByNickname("USER")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", got.Id)
+}
+
+func TestMatchRoundTrip(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "host")
+	err := repos.Rooms.Store(&core.Room{Id: "room", Host: "host", Users: []string{"host"}})
+	if err != nil {
+		panic(err)
+	}
+
+	match := core.Match{
+		Id:      "match",
+		RoomId:  "room",
+		Players: []string{"host"},
+		Limit:   125,
+		Rounds:  []core.Round{{SessionId: "session", Dealer: "host", Scores: map[string]int{"host": 20}}},
+	}
+	assert.NoError(t, repos.Matches.Store(&match))
+
+	got, err := repos.Matches.Get("match")
+	assert.NoError(t, err)
+	assert.Equal(t, match, got)
+}
+
+func TestUnitOfWorkRollback(t *testing.T) {
+	sqlDB := newTestDB(t)
+	uow := NewUnitOfWork(sqlDB)
+	repos := NewRepositories(sqlDB)
+
+	failed := errors.New("failed")
+	committed := 0
+	err := uow.Do(func(repos core.Repositories) error {
+		storeUsers(repos, "user")
+		repos.AfterCommit(func() { committed++ })
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	_, err = repos.Users.Get("user")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.Zero(t, committed, "what waits for the commit is dropped with the rollback")
+
+	err = uow.Do(func(repos core.Repositories) error {
+		storeUsers(repos, "user")
+		repos.AfterCommit(func() { committed++ })
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = repos.Users.Get("user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, committed)
+}
+
+func TestConcurrentUnitsOfWork(t *testing.T) {
+	sqlDB := newTestDB(t)
+	uow := NewUnitOfWork(sqlDB)
+	repos := NewRepositories(sqlDB)
+	storeUsers(repos, "host")
+	err := repos.Rooms.Store(&core.Room{Id: "room", Host: "host"})
+	if err != nil {
+		panic(err)
+	}
+	err = repos.Matches.Store(&core.Match{Id: "match", RoomId: "room"})
+	if err != nil {
+		panic(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := uow.Do(func(repos core.Repositories) error {
+				match, err := repos.Matches.Get("match")
+				if err != nil {
+					return err
+				}
+				match.Limit++
+				return repos.Matches.Store(&match)
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	match, err := repos.Matches.Get("match")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 20, match.Limit)
+}
+
+func TestTokens(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "user", "other")
+
+	created := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	phone := core.Token{
+		Id:         "phone",
+		UserId:     "user",
+		Hash:       "phone hash",
+		Device:     "phone",
+		CreatedAt:  created,
+		LastUsedAt: created,
+		ExpiresAt:  created.Add(time.Hour),
+	}
+	desktop := phone
+	desktop.Id, desktop.Hash, desktop.Device = "desktop", "desktop hash", "desktop"
+	desktop.CreatedAt = created.Add(time.Minute)
+	other := phone
+	other.Id, other.UserId, other.Hash = "other", "other", "other hash"
+	for _, token := range []core.Token{desktop, phone, other} {
+		assert.NoError(t, repos.Tokens.Store(&token))
+	}
+
+	phone.LastUsedAt = created.Add(time.Minute)
+	phone.ExpiresAt = created.Add(2 * time.Hour)
+	assert.NoError(t, repos.Tokens.Store(&phone))
+	got, err := repos.Tokens.GetByHash("phone hash")
+	assert.NoError(t, err)
+	assert.True(t, phone.ExpiresAt.Equal(got.ExpiresAt))
+	assert.True(t, phone.LastUsedAt.Equal(got.LastUsedAt))
+
+	phone.Hash = "rotated phone hash"
+	assert.NoError(t, repos.Tokens.Store(&phone))
+	_, err = repos.Tokens.GetByHash("phone hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows, "a rotated token must not be found by its old hash")
+	_, err = repos.Tokens.GetByHash("rotated phone hash")
+	assert.NoError(t, err)
+
+	tokens, err := repos.Tokens.ListForUser("user")
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, "phone", tokens[0].Id)
+	assert.Equal(t, "desktop", tokens[1].Id)
+
+	assert.NoError(t, repos.Tokens.Delete("phone"))
+	_, err = repos.Tokens.GetByHash("rotated phone hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.NoError(t, repos.Tokens.DeleteForUser("user"))
+	tokens, err = repos.Tokens.ListForUser("user")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+	_, err = repos.Tokens.GetByHash("other hash")
+	assert.NoError(t, err)
+}
+
+func TestActionTokens(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "user")
+
+	expires := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	verify := core.ActionToken{Id: "verify", UserId: "user", Purpose: core.PurposeVerifyEmail, ExpiresAt: expires}
+	reset := core.ActionToken{Id: "reset", UserId: "user", Purpose: core.PurposeResetPassword, ExpiresAt: expires}
+	for _, token := range []core.ActionToken{verify, reset} {
+		assert.NoError(t, repos.ActionTokens.Store(&token))
+	}
+
+	got, err := repos.ActionTokens.Consume("verify")
+	assert.NoError(t, err)
+	assert.Equal(t, core.PurposeVerifyEmail, got.Purpose)
+	assert.True(t, expires.Equal(got.ExpiresAt))
+	_, err = repos.ActionTokens.Consume("verify")
+	assert.ErrorIs(t, err, sql.ErrNoRows, "a token is consumed once")
+
+	assert.NoError(t, repos.ActionTokens.DeleteForUser("user", core.PurposeResetPassword))
+	_, err = repos.ActionTokens.Consume("reset")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	user, err := repos.Users.Get("user")
+	assert.NoError(t, err)
+	assert.False(t, user.EmailVerified)
+	user.EmailVerified = true
+	assert.NoError(t, repos.Users.Store(&user))
+	user, err = repos.Users.GetByEmail("user@bridge.test")
+	assert.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+}
+
+func TestGuests(t *testing.T) {
+	repos := NewRepositories(newTestDB(t))
+	storeUsers(repos, "user")
+	guest := core.User{Id: "guest", Nickname: "Guest123456", Role: core.RolePlayer, Guest: true}
+	assert.NoError(t, repos.Users.Store(&guest))
+	created := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	token := core.Token{Id: "token", UserId: "guest", Hash: "hash", CreatedAt: created, LastUsedAt: created, ExpiresAt: created}
+	assert.NoError(t, repos.Tokens.Store(&token))
+	room := core.Room{Id: "room", Host: "guest", Users: []string{"guest", "user"}}
+	assert.NoError(t, repos.Rooms.Store(&room))
+
+	guests, err := repos.Users.ListGuests()
+	assert.NoError(t, err)
+	assert.Equal(t, []core.User{guest}, guests)
+
+	assert.NoError(t, repos.Users.Delete("guest"))
+	_, err = repos.Users.Get("guest")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = repos.Tokens.GetByHash("hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows, "the tokens go with the user")
+	_, err = repos.Rooms.Get("room")
+	assert.Error(t, err, "the rooms it hosts go with the user")
+	_, err = repos.Users.Get("user")
+	assert.NoError(t, err)
+
+	guests, err = repos.Users.ListGuests()
+	assert.NoError(t, err)
+	assert.Empty(t, guests)
+}
@@ -0,0 +1,78 @@
+// Package sqlite stores every aggregate in a SQLite database,
+// for self-hosted setups that don't want to run Postgres.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+// dbtx is what repositories need from a connection, both *sql.DB and *sql.Tx have it
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func newRepositories(db dbtx) core.Repositories {
+	return core.Repositories{
+		Sessions:     &SessionRepository{db: db},
+		Players:      &PlayerRepository{db: db},
+		Users:        &UserRepository{db: db},
+		Rooms:        &RoomRepository{db: db},
+		Matches:      &MatchRepository{db: db},
+		Tokens:       &TokenRepository{db: db},
+		ActionTokens: &ActionTokenRepository{db: db},
+	}
+}
+
+// NewRepositories returns repositories working outside of any transaction
+func NewRepositories(db *sql.DB) core.Repositories {
+	return newRepositories(db)
+}
+
+type UnitOfWork struct {
+	db *sql.DB
+}
+
+func NewUnitOfWork(db *sql.DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+// Do runs fn in a transaction, which is committed only if fn succeeds
+func (u *UnitOfWork) Do(fn func(core.Repositories) error) error {
+	tx, err := u.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to begin transaction: %w", err)
+	}
+	// Rollback does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	var committed core.Committed
+	repos := newRepositories(tx)
+	repos.AfterCommit = committed.Add
+	err = fn(repos)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Unable to commit transaction: %w", err)
+	}
+	committed.Run()
+	return nil
+}
+
+// checkVersion tells if a versioned upsert has found the row at the expected version
+func checkVersion(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return core.VersionConflictError
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type UserRepository struct {
+	db dbtx
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+const SelectUser = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE user_id = $1
+`
+
+func (ur *UserRepository) Get(user_id string) (core.User, error) {
+	var user core.User
+	err := ur.db.QueryRow(SelectUser, user_id).Scan(
+		&user.Id,
+		&user.Email,
+		&user.Password,
+		&user.Nickname,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
+	)
+	if err != nil {
+		return core.User{}, fmt.Errorf("Unable to get user for id %s: %w", user_id, err)
+	}
+
+	return user, nil
+}
+
+const SelectUserByEmail = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE lower(email) = lower($1)
+`
+
+func (ur *UserRepository) GetByEmail(email string) (core.User, error) {
+	var user core.User
+	err := ur.db.QueryRow(SelectUserByEmail, email).Scan(
+		&user.Id,
+		&user.Email,
+		&user.Password,
+		&user.Nickname,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
+	)
+	if err != nil {
+		return core.User{}, fmt.Errorf("Unable to get user by email %s: %w", email, err)
+	}
+	return user, nil
+}
+
+const SelectUserByNickname = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE lower(nickname) = lower($1)
+`
+
+func (ur *UserRepository) GetByNickname(nickname string) (core.User, error) {
+	var user core.User
+	err := ur.db.QueryRow(SelectUserByNickname, nickname).Scan(
+		&user.Id,
+		&user.Email,
+		&user.Password,
+		&user.Nickname,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
+	)
+	if err != nil {
+		return core.User{}, fmt.Errorf("Unable to get user by nickname %s: %w", nickname, err)
+	}
+	return user, nil
+}
+
+// SelectUsersForRoom walks the user_ids array of the room,
+// so users come in the order they joined
+const SelectUsersForRoom = `
+SELECT users.user_id, email, password, nickname, role, email_verified, guest
+FROM rooms, json_each(rooms.user_ids) AS member
+JOIN users ON users.user_id = member.value
+WHERE rooms.room_id = $1
+ORDER BY member.key
+`
+
+func (ur *UserRepository) GetForRoom(room_id string) ([]core.User, error) {
+	rows, err := ur.db.Query(SelectUsersForRoom, room_id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get users for room id %s: %w", room_id, err)
+	}
+	defer rows.Close()
+
+	var users []core.User
+	for rows.Next() {
+		var user core.User
+		if err := rows.Scan(
+			&user.Id,
+			&user.Email,
+			&user.Password,
+			&user.Nickname,
+			&user.Role,
+			&user.EmailVerified,
+			&user.Guest,
+		); err != nil {
+			return nil, fmt.Errorf("Unable to get users for room id %s: %w", room_id, err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to get users for room id %s: %w", room_id, err)
+	}
+	return users, nil
+}
+
+const SelectGuests = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE guest
+`
+
+func (ur *UserRepository) ListGuests() ([]core.User, error) {
+	rows, err := ur.db.Query(SelectGuests)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list guests: %w", err)
+	}
+	defer rows.Close()
+
+	var users []core.User
+	for rows.Next() {
+		var user core.User
+		if err := rows.Scan(
+			&user.Id,
+			&user.Email,
+			&user.Password,
+			&user.Nickname,
+			&user.Role,
+			&user.EmailVerified,
+			&user.Guest,
+		); err != nil {
+			return nil, fmt.Errorf("Unable to list guests: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list guests: %w", err)
+	}
+	return users, nil
+}
+
+const UpsertUser = `
+INSERT INTO users (user_id, email, password, nickname, role, email_verified, guest)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id)
+DO UPDATE
+SET
+	email = excluded.email,
+	password = excluded.password,
+	nickname = excluded.nickname,
+	role = excluded.role,
+	email_verified = excluded.email_verified,
+	guest = excluded.guest
+`
+
+func (ur *UserRepository) Store(user *core.User) error {
+	_, err := ur.db.Exec(UpsertUser,
+		user.Id,
+		user.Email,
+		user.Password,
+		user.Nickname,
+		user.Role,
+		user.EmailVerified,
+		user.Guest,
+	)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("Unable to store user for id %s: %w", user.Id, core.UserExistsError)
+	} else if err != nil {
+		return fmt.Errorf("Unable to store user for id %s: %w", user.Id, err)
+	}
+
+	return nil
+}
+
+const DeleteUser = `
+DELETE FROM users
+WHERE user_id = $1
+`
+
+// Delete removes the user along with what references it: tokens, players,
+// the rooms it hosts and the sessions it is to move in
+func (ur *UserRepository) Delete(user_id string) error {
+	_, err := ur.db.Exec(DeleteUser, user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete user for id %s: %w", user_id, err)
+	}
+	return nil
+}
+
+// isUniqueViolation tells if err is about a unique index other than the primary key,
+// which the upserts take care of
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
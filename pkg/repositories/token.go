@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mrbttf/bridge-server/pkg/core"
+)
+
+type TokenRepository struct {
+	db dbtx
+}
+
+func NewTokenRepository(db *sql.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func scanToken(row scanner) (core.Token, error) {
+	var token core.Token
+	err := row.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Hash,
+		&token.Device,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.ExpiresAt,
+	)
+	return token, err
+}
+
+const SelectTokenByHash = `
+SELECT token_id, user_id, hash, device, created_at, last_used_at, expires_at
+FROM tokens
+WHERE hash = $1
+`
+
+func (tr *TokenRepository) GetByHash(hash string) (core.Token, error) {
+	token, err := scanToken(tr.db.QueryRow(SelectTokenByHash, hash))
+	if err != nil {
+		return core.Token{}, fmt.Errorf("Unable to get token by hash: %w", err)
+	}
+
+	return token, nil
+}
+
+const SelectTokensForUser = `
+SELECT token_id, user_id, hash, device, created_at, last_used_at, expires_at
+FROM tokens
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+func (tr *TokenRepository) ListForUser(user_id string) ([]core.Token, error) {
+	rows, err := tr.db.Query(SelectTokensForUser, user_id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list tokens for user id %s: %w", user_id, err)
+	}
+	defer rows.Close()
+
+	var tokens []core.Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list tokens for user id %s: %w", user_id, err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list tokens for user id %s: %w", user_id, err)
+	}
+	return tokens, nil
+}
+
+const UpsertToken = `
+INSERT INTO tokens (token_id, user_id, hash, device, created_at, last_used_at, expires_at)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (token_id)
+DO UPDATE
+SET
+	hash = EXCLUDED.hash,
+	device = EXCLUDED.device,
+	last_used_at = EXCLUDED.last_used_at,
+	expires_at = EXCLUDED.expires_at
+`
+
+func (tr *TokenRepository) Store(token *core.Token) error {
+	_, err := tr.db.Exec(UpsertToken,
+		token.Id,
+		token.UserId,
+		token.Hash,
+		token.Device,
+		token.CreatedAt,
+		token.LastUsedAt,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store token for id %s: %w", token.Id, err)
+	}
+
+	return nil
+}
+
+const DeleteToken = `
+DELETE FROM tokens
+WHERE token_id = $1
+`
+
+func (tr *TokenRepository) Delete(token_id string) error {
+	_, err := tr.db.Exec(DeleteToken, token_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete token for id %s: %w", token_id, err)
+	}
+
+	return nil
+}
+
+const DeleteTokensForUser = `
+DELETE FROM tokens
+WHERE user_id = $1
+`
+
+func (tr *TokenRepository) DeleteForUser(user_id string) error {
+	_, err := tr.db.Exec(DeleteTokensForUser, user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete tokens for user id %s: %w", user_id, err)
+	}
+
+	return nil
+}
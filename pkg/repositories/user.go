@@ -2,13 +2,15 @@ package repositories
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/mrbttf/bridge-server/pkg/core"
 )
 
 type UserRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUserRepository(db *sql.DB) *UserRepository {
@@ -16,7 +18,7 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 }
 
 const SelectUser = `
-SELECT user_id, email, password, nickname, token
+SELECT user_id, email, password, nickname, role, email_verified, guest
 FROM users
 WHERE user_id = $1
 `
@@ -28,7 +30,9 @@ func (ur *UserRepository) Get(user_id string) (core.User, error) {
 		&user.Email,
 		&user.Password,
 		&user.Nickname,
-		&user.Token,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
 	)
 	if err != nil {
 		return core.User{}, fmt.Errorf("Unable to get user for id %s: %w", user_id, err)
@@ -38,9 +42,9 @@ func (ur *UserRepository) Get(user_id string) (core.User, error) {
 }
 
 const SelectUserByEmail = `
-SELECT user_id, email, password, nickname, token
+SELECT user_id, email, password, nickname, role, email_verified, guest
 FROM users
-WHERE email = $1
+WHERE lower(email) = lower($1)
 `
 
 func (ur *UserRepository) GetByEmail(email string) (core.User, error) {
@@ -50,7 +54,9 @@ func (ur *UserRepository) GetByEmail(email string) (core.User, error) {
 		&user.Email,
 		&user.Password,
 		&user.Nickname,
-		&user.Token,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
 	)
 	if err != nil {
 		return core.User{}, fmt.Errorf("Unable to get user by email %s: %w", email, err)
@@ -58,8 +64,31 @@ func (ur *UserRepository) GetByEmail(email string) (core.User, error) {
 	return user, nil
 }
 
+const SelectUserByNickname = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE lower(nickname) = lower($1)
+`
+
+func (ur *UserRepository) GetByNickname(nickname string) (core.User, error) {
+	var user core.User
+	err := ur.db.QueryRow(SelectUserByNickname, nickname).Scan(
+		&user.Id,
+		&user.Email,
+		&user.Password,
+		&user.Nickname,
+		&user.Role,
+		&user.EmailVerified,
+		&user.Guest,
+	)
+	if err != nil {
+		return core.User{}, fmt.Errorf("Unable to get user by nickname %s: %w", nickname, err)
+	}
+	return user, nil
+}
+
 const SelectUsersForRoom = `
-SELECT user_id, email, password, nickname, token
+SELECT user_id, email, password, nickname, role, email_verified, guest
 FROM users
 JOIN rooms ON user_id = any(rooms.user_ids)
 WHERE rooms.room_id = $1
@@ -80,7 +109,9 @@ func (ur *UserRepository) GetForRoom(room_id string) ([]core.User, error) {
 			&user.Email,
 			&user.Password,
 			&user.Nickname,
-			&user.Token,
+			&user.Role,
+			&user.EmailVerified,
+			&user.Guest,
 		); err != nil {
 			return nil, fmt.Errorf("Unable to get users for room id %s: %w", room_id, err)
 		}
@@ -89,17 +120,54 @@ func (ur *UserRepository) GetForRoom(room_id string) ([]core.User, error) {
 	return users, nil
 }
 
+const SelectGuests = `
+SELECT user_id, email, password, nickname, role, email_verified, guest
+FROM users
+WHERE guest
+`
+
+func (ur *UserRepository) ListGuests() ([]core.User, error) {
+	rows, err := ur.db.Query(SelectGuests)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list guests: %w", err)
+	}
+	defer rows.Close()
+
+	var users []core.User
+	for rows.Next() {
+		var user core.User
+		if err := rows.Scan(
+			&user.Id,
+			&user.Email,
+			&user.Password,
+			&user.Nickname,
+			&user.Role,
+			&user.EmailVerified,
+			&user.Guest,
+		); err != nil {
+			return nil, fmt.Errorf("Unable to list guests: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list guests: %w", err)
+	}
+	return users, nil
+}
+
 const UpsertUser = `
-INSERT INTO users (user_id, email, password, nickname, token)
-VALUES($1, $2, $3, $4, $5) 
+INSERT INTO users (user_id, email, password, nickname, role, email_verified, guest)
+VALUES($1, $2, $3, $4, $5, $6, $7) 
 ON CONFLICT (user_id) 
 WHERE user_id = $1 
 DO UPDATE
 SET 
 	email = EXCLUDED.email, 
 	password = EXCLUDED.password, 
-	nickname = EXCLUDED.nickname, 
-	token = EXCLUDED.token
+	nickname = EXCLUDED.nickname,
+	role = EXCLUDED.role,
+	email_verified = EXCLUDED.email_verified,
+	guest = EXCLUDED.guest
 `
 
 func (ur *UserRepository) Store(user *core.User) error {
@@ -108,11 +176,37 @@ func (ur *UserRepository) Store(user *core.User) error {
 		user.Email,
 		user.Password,
 		user.Nickname,
-		user.Token,
+		user.Role,
+		user.EmailVerified,
+		user.Guest,
 	)
-	if err != nil {
+	if isUniqueViolation(err) {
+		return fmt.Errorf("Unable to store user for id %s: %w", user.Id, core.UserExistsError)
+	} else if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+const DeleteUser = `
+DELETE FROM users
+WHERE user_id = $1
+`
+
+// Delete removes the user along with what references it: tokens, players,
+// the rooms it hosts and the sessions it is to move in
+func (ur *UserRepository) Delete(user_id string) error {
+	_, err := ur.db.Exec(DeleteUser, user_id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete user for id %s: %w", user_id, err)
+	}
+	return nil
+}
+
+// isUniqueViolation tells if err is about a unique index other than the primary key,
+// which the upserts take care of
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -2,44 +2,110 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+	"github.com/mrbttf/bridge-server/pkg/core"
 )
 
 var (
-	ErrServerTokenNotFound  = errors.New("token not found")
+	ErrServerTokenNotFound       = errors.New("token not found")
+	ErrServerPlayerNotAuthorized = errors.New("player_id does not match user_id")
+	ErrServerUnauthorized        = errors.New("Unauthorized")
+	ErrServerAuthHeaderInvalid   = errors.New("Authorization header is not a Bearer token")
 )
 
+// AuthMiddleware authenticates the request by the token in the Authorization header:
+//
+//	Authorization: Bearer <token>
+//
+// and puts the token into the request context, handlers get the user from there.
+// Unless legacy auth is off, requests without the header may still send
+// user_id and token in the query of GET or in the JSON body of other methods.
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var data *AuthRequest
-
-		var err error
-		if r.Method == "GET" {
-			data, err = getQueryParams(r)
-			if err != nil {
-				renderError(w, r, http.StatusBadRequest, ErrServerForbidden, err)
-				return
-			}
-		} else {
-			data, err = getBodyParams(r)
-			if err != nil {
-				renderError(w, r, http.StatusBadRequest, ErrServerForbidden, err)
-				return
-			}
+		header := r.Header.Get("Authorization")
+		if header == "" && s.legacyAuth {
+			s.legacyAuthenticate(next, w, r)
+			return
 		}
 
-		err = s.authService.ValidateToken(data.UserId, data.Token)
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || secret == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			renderError(w, r, http.StatusUnauthorized, ErrServerUnauthorized, ErrServerAuthHeaderInvalid)
+			return
+		}
+		token, err := s.authService.Authenticate(secret)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			renderError(w, r, http.StatusUnauthorized, ErrServerUnauthorized, err)
+			return
+		}
+		next.ServeHTTP(w, withToken(r, token))
+	})
+}
+
+// legacyAuthenticate is the deprecated way of AuthMiddleware,
+// responses tell clients so with the Deprecation header
+func (s *Server) legacyAuthenticate(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+
+	var data *AuthRequest
+	var err error
+	if r.Method == "GET" {
+		data, err = getQueryParams(r)
+		if err != nil {
+			renderError(w, r, http.StatusBadRequest, ErrServerForbidden, err)
+			return
+		}
+	} else {
+		data, err = getBodyParams(r)
 		if err != nil {
 			renderError(w, r, http.StatusBadRequest, ErrServerForbidden, err)
 			return
 		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	token, err := s.authService.ValidateToken(data.UserId, data.Token)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerForbidden, err)
+		return
+	}
+	next.ServeHTTP(w, withToken(r, token))
+}
+
+type tokenContextKey struct{}
+
+func withToken(r *http.Request, token core.Token) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token))
+}
+
+// requestToken returns the token AuthMiddleware has authenticated the request with
+func requestToken(r *http.Request) core.Token {
+	token, _ := r.Context().Value(tokenContextKey{}).(core.Token)
+	return token
+}
+
+// requestUserId returns the user AuthMiddleware has authenticated
+func requestUserId(r *http.Request) string {
+	return requestToken(r).UserId
+}
+
+// actingUser returns the authenticated user if the request acts on its behalf.
+// Request bodies may still name the user, as player_id or user_id,
+// but then it has to be the authenticated one.
+func actingUser(r *http.Request, claimed string) (string, error) {
+	user_id := requestUserId(r)
+	if claimed != "" && claimed != user_id {
+		return "", ErrServerPlayerNotAuthorized
+	}
+	return user_id, nil
 }
 
 func getQueryParams(r *http.Request) (*AuthRequest, error) {
@@ -69,3 +135,28 @@ func getBodyParams(r *http.Request) (*AuthRequest, error) {
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
 	return data, nil
 }
+
+// BrowserAuth passes the token on to AuthMiddleware as the Authorization header
+// for browsers, which cannot set headers on a WebSocket or an EventSource. A
+// WebSocket sends it with the bearer protocol, anything else as access_token in
+// the query. Tokens in the query end up in logs, the protocol is preferred.
+func BrowserAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := browserToken(r); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func browserToken(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == wsBearerProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
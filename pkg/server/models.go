@@ -2,49 +2,60 @@ package server
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/MrBTTF/gophercises/deck"
 	"github.com/go-chi/render"
 	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/rules"
 	"github.com/mrbttf/bridge-server/pkg/repositories"
 )
 
 type sessionGetRequest struct {
 	SessionId string `json:"session_id" example:"string"`
-	AuthRequest
+	DefaultRequest
 }
 
 type sessionCreateRequest struct {
 	RoomId string `json:"room_id" example:"string"`
-	AuthRequest
+	DefaultRequest
 }
 
 type sessionGetByUserRequest struct {
-	UserId string `json:"user_id" example:"string"`
-	AuthRequest
+	UserId string `json:"user_id,omitempty" example:"string"`
+	DefaultRequest
 }
 
 type sessionLayRequest struct {
 	SessionId string `json:"session_id" example:"string"`
-	PlayerId  string `json:"player_id" example:"string"`
+	PlayerId  string `json:"player_id,omitempty" example:"string"`
 	Card      string `json:"card" example:"string"`
-	AuthRequest
+	Suit      string `json:"suit,omitempty" example:"H"`
+	DefaultRequest
 }
 
 type sessionPullRequest struct {
 	SessionId string `json:"session_id" example:"string"`
-	PlayerId  string `json:"player_id" example:"string"`
-	AuthRequest
+	PlayerId  string `json:"player_id,omitempty" example:"string"`
+	DefaultRequest
+}
+
+type sessionBridgeRequest struct {
+	SessionId string `json:"session_id" example:"string"`
+	PlayerId  string `json:"player_id,omitempty" example:"string"`
+	Declare   bool   `json:"declare" example:"true"`
+	DefaultRequest
 }
 
 type sessionNextTurnRequest struct {
 	SessionId string `json:"session_id" example:"string"`
-	PlayerId  string `json:"player_id" example:"string"`
-	AuthRequest
+	PlayerId  string `json:"player_id,omitempty" example:"string"`
+	DefaultRequest
 }
 
 type sessionCloseRequest struct {
 	SessionId string `json:"session_id" example:"string"`
-	AuthRequest
+	DefaultRequest
 }
 
 type authRegisterRequest struct {
@@ -54,42 +65,149 @@ type authRegisterRequest struct {
 	DefaultRequest
 }
 
+// authLoginRequest names the device the token is for, the User-Agent if device is empty
 type authLoginRequest struct {
 	Email    string `json:"email" example:"string"`
 	Password string `json:"password" example:"string"`
+	Device   string `json:"device,omitempty" example:"Pixel 7"`
 	DefaultRequest
 }
 
+// authLogoutRequest logs out the device of token_id or, without it, the current one
 type authLogoutRequest struct {
-	Email string `json:"email" example:"string"`
+	TokenId string `json:"token_id,omitempty" example:"string"`
+	DefaultRequest
+}
+
+type authRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"string"`
+	DefaultRequest
+}
+
+// authGuestRequest names the device the token is for, the User-Agent if device is empty
+type authGuestRequest struct {
+	Device string `json:"device,omitempty" example:"Pixel 7"`
+	DefaultRequest
+}
+
+// authClaimRequest keeps the generated nickname if nickname is empty
+type authClaimRequest struct {
+	Email    string `json:"email" example:"string"`
+	Password string `json:"password" example:"string"`
+	Nickname string `json:"nickname,omitempty" example:"string"`
+	DefaultRequest
+}
+
+type authVerifyRequest struct {
 	Token string `json:"token" example:"string"`
 	DefaultRequest
 }
 
+type authForgotRequest struct {
+	Email string `json:"email" example:"string"`
+	DefaultRequest
+}
+
+type authResetRequest struct {
+	Token    string `json:"token" example:"string"`
+	Password string `json:"password" example:"string"`
+	DefaultRequest
+}
+
 type roomGetRequest struct {
 	RoomId string `json:"room_id" example:"string"`
-	AuthRequest
+	DefaultRequest
 }
 
 type roomCreateRequest struct {
-	HostId string `json:"host_id" example:"string"`
-	AuthRequest
+	HostId string `json:"host_id,omitempty" example:"string"`
+	DefaultRequest
 }
 
 type roomJoinRequest struct {
 	RoomId string `json:"room_id" example:"string"`
-	UserId string `json:"user_id" example:"string"`
-	AuthRequest
+	UserId string `json:"user_id,omitempty" example:"string"`
+	DefaultRequest
 }
 
 type roomListRequest struct {
-	Open bool `json:"open" example:true`
-	AuthRequest
+	Open bool `json:"open" example:"true"`
+	DefaultRequest
+}
+
+// roomSettingsRequest changes only the settings present in the body
+type roomSettingsRequest struct {
+	RoomId        string   `json:"room_id" example:"string"`
+	MustLay       []string `json:"must_lay,omitempty" example:"6"`
+	MustLayOrPull []string `json:"must_lay_or_pull,omitempty" example:"8"`
+	Stack         []string `json:"stack,omitempty" example:"8"`
+	// Effects replace the effects of every rank, a rank missing from them has none
+	Effects    map[string]RoomEffect `json:"effects,omitempty"`
+	DeckSize   int                   `json:"deck_size,omitempty" example:"36"`
+	DealerHand int                   `json:"dealer_hand,omitempty" example:"5"`
+	PlayerHand int                   `json:"player_hand,omitempty" example:"4"`
+	DefaultRequest
+}
+
+// Settings applies the request to settings, a rank that is not one
+// is reported as a *core.ValidationError
+func (req *roomSettingsRequest) Settings(settings core.RoomSettings) (core.RoomSettings, error) {
+	invalid := &core.ValidationError{}
+	ranks := func(field string, values []string, ranks *[]deck.Rank) {
+		if values == nil {
+			return
+		}
+		parsed, err := stringsToRanks(values)
+		if err != nil {
+			invalid.Add(field, "must be ranks among A, 2 to 9, T, J, Q and K")
+			return
+		}
+		*ranks = parsed
+	}
+	ranks("must_lay", req.MustLay, &settings.Rules.MustLayRanks)
+	ranks("must_lay_or_pull", req.MustLayOrPull, &settings.Rules.MustLayOrPullRanks)
+	ranks("stack", req.Stack, &settings.Rules.StackRanks)
+	if req.Effects != nil {
+		effects, err := stringsToEffects(req.Effects)
+		if err != nil {
+			invalid.Add("effects", "must be keyed by ranks among A, 2 to 9, T, J, Q and K")
+		}
+		settings.Rules.Effects = effects
+	}
+	if err := invalid.Err(); err != nil {
+		return core.RoomSettings{}, err
+	}
+	if req.DeckSize != 0 {
+		settings.DeckSize = req.DeckSize
+	}
+	if req.DealerHand != 0 {
+		settings.DealerHand = req.DealerHand
+	}
+	if req.PlayerHand != 0 {
+		settings.PlayerHand = req.PlayerHand
+	}
+	return settings, nil
+}
+
+type roomLeaveRequest struct {
+	RoomId string `json:"room_id" example:"string"`
+	DefaultRequest
 }
 
 type roomDeleteRequest struct {
 	RoomId string `json:"room_id" example:"string"`
-	AuthRequest
+	DefaultRequest
+}
+
+type matchCreateRequest struct {
+	RoomId string `json:"room_id" example:"string"`
+	Limit  int    `json:"limit" example:"125"`
+	DefaultRequest
+}
+
+type matchNextRoundRequest struct {
+	MatchId string `json:"match_id" example:"string"`
+	DefaultRequest
 }
 
 type PlayerResponse struct {
@@ -110,26 +228,115 @@ func NewPlayerResponse(player *core.Player) *PlayerResponse {
 	}
 }
 
-type SessionResponse struct {
-	Id            string   `json:"id" example:"string"`
-	Players       []string `json:"players" example:"string"`
-	Deck          []string `json:"deck" example:"string"`
-	Table         []string `json:"table" example:"string"`
-	CurrentPlayer PlayerResponse
+// OpponentResponse shows another player of the session without their cards
+type OpponentResponse struct {
+	Id       string `json:"id" example:"string"`
+	Name     string `json:"name" example:"string"`
+	HandSize int    `json:"hand_size" example:"4"`
+	State    string `json:"state" example:"string"`
 }
 
-func NewSessionResponse(session *core.Session, player *core.Player) *SessionResponse {
-	return &SessionResponse{
+func NewOpponentResponse(player *core.Player) *OpponentResponse {
+	return &OpponentResponse{
+		Id:       player.Id,
+		Name:     player.Nickname,
+		HandSize: len(player.Cards),
+		State:    player.State.String(),
+	}
+}
+
+type SessionResponse struct {
+	Id            string             `json:"id" example:"string"`
+	Players       []string           `json:"players" example:"string"`
+	DeckSize      int                `json:"deck_size" example:"20"`
+	TableTop      string             `json:"table_top" example:"string"`
+	Player        PlayerResponse     `json:"player"`
+	Opponents     []OpponentResponse `json:"opponents"`
+	CurrentPlayer string             `json:"current_player" example:"string"`
+	Finished      bool               `json:"finished" example:"false"`
+	Winner        string             `json:"winner" example:"string"`
+	Reshuffles    int                `json:"reshuffles" example:"0"`
+	DemandedSuit  string             `json:"demanded_suit" example:"H"`
+	Direction     int                `json:"direction" example:"1"`
+	Bridge        bool               `json:"bridge" example:"false"`
+}
+
+// NewSessionResponse shows the session the way viewer_id sees it at the table:
+// their own hand, the size of the other hands and of the deck, and the top of the table.
+// players must hold everybody in the session.
+func NewSessionResponse(session *core.Session, viewer_id string, players []core.Player) *SessionResponse {
+	var demandedSuit string
+	if session.DemandedSuit != nil {
+		demandedSuit = repositories.SuitToString(*session.DemandedSuit)
+	}
+	var tableTop string
+	if len(session.Table) > 0 {
+		tableTop = repositories.CardToString(session.Table[len(session.Table)-1])
+	}
+	response := &SessionResponse{
 		Id:            session.Id,
-		Deck:          repositories.DeckToString(session.Deck),
-		Table:         repositories.DeckToString(session.Table),
 		Players:       session.Players,
-		CurrentPlayer: *NewPlayerResponse(player),
+		DeckSize:      len(session.Deck),
+		TableTop:      tableTop,
+		Opponents:     make([]OpponentResponse, 0, len(players)),
+		CurrentPlayer: session.CurrentPlayer,
+		Finished:      session.IsFinished(),
+		Winner:        session.Winner,
+		Reshuffles:    session.Reshuffles,
+		DemandedSuit:  demandedSuit,
+		Direction:     session.Direction,
+		Bridge:        session.Bridge,
+	}
+	for _, player := range players {
+		if player.Id == viewer_id {
+			response.Player = *NewPlayerResponse(&player)
+		} else {
+			response.Opponents = append(response.Opponents, *NewOpponentResponse(&player))
+		}
+	}
+	return response
+}
+
+// SessionEventResponse is pushed to the session WebSocket
+type SessionEventResponse struct {
+	Type      string `json:"type" example:"card_laid"`
+	SessionId string `json:"session_id" example:"string"`
+	PlayerId  string `json:"player_id,omitempty" example:"string"`
+	Card      string `json:"card,omitempty" example:"string"`
+}
+
+func NewSessionEventResponse(event *core.SessionEvent) *SessionEventResponse {
+	var card string
+	if event.Card != nil {
+		card = repositories.CardToString(*event.Card)
+	}
+	return &SessionEventResponse{
+		Type:      string(event.Type),
+		SessionId: event.SessionId,
+		PlayerId:  event.PlayerId,
+		Card:      card,
+	}
+}
+
+// RoomEventResponse is the data of an event in the lobby stream
+type RoomEventResponse struct {
+	Id     int64  `json:"id" example:"1"`
+	Type   string `json:"type" example:"user_joined"`
+	RoomId string `json:"room_id" example:"string"`
+	UserId string `json:"user_id,omitempty" example:"string"`
+}
+
+func NewRoomEventResponse(event *core.RoomEvent) *RoomEventResponse {
+	return &RoomEventResponse{
+		Id:     event.Id,
+		Type:   string(event.Type),
+		RoomId: event.RoomId,
+		UserId: event.UserId,
 	}
 }
 
 type sessionGetResponse struct {
-	Session SessionResponse `json:"session" example:SessionResponse`
+	Session SessionResponse `json:"session"`
 	DefaultResponse
 }
 
@@ -152,17 +359,27 @@ type authRegisterResponse struct {
 	DefaultResponse
 }
 
+// UserResponse carries the credentials of a login, refresh_token is there
+// only if the server issues signed access tokens
 type UserResponse struct {
-	Id       string `json:"id" example:"string"`
-	Nickname string `json:"nickname" example:"string"`
-	Token    string `json:"token" example:"string"`
+	Id             string    `json:"id" example:"string"`
+	Nickname       string    `json:"nickname" example:"string"`
+	EmailVerified  bool      `json:"email_verified" example:"false"`
+	Guest          bool      `json:"guest" example:"false"`
+	Token          string    `json:"token" example:"string"`
+	RefreshToken   string    `json:"refresh_token,omitempty" example:"string"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
 }
 
-func NewUserResponse(user *core.User) *UserResponse {
+func NewUserResponse(user *core.User, credentials core.Credentials) *UserResponse {
 	return &UserResponse{
-		Id:       user.Id,
-		Nickname: user.Nickname,
-		Token:    user.Token,
+		Id:             user.Id,
+		Nickname:       user.Nickname,
+		EmailVerified:  user.EmailVerified,
+		Guest:          user.Guest,
+		Token:          credentials.Token,
+		RefreshToken:   credentials.RefreshToken,
+		TokenExpiresAt: credentials.ExpiresAt,
 	}
 }
 
@@ -179,7 +396,7 @@ func NewUserResponseSecure(user *core.User) *UserResponseSecure {
 }
 
 type authLoginResponse struct {
-	User UserResponse `json:"user" example:UserResponse`
+	User UserResponse `json:"user"`
 	DefaultResponse
 }
 
@@ -187,11 +404,104 @@ type authLogoutResponse struct {
 	DefaultResponse
 }
 
+type authRefreshResponse struct {
+	Token        string    `json:"token" example:"string"`
+	RefreshToken string    `json:"refresh_token" example:"string"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	DefaultResponse
+}
+
+func NewAuthRefreshResponse(credentials core.Credentials) *authRefreshResponse {
+	return &authRefreshResponse{
+		Token:        credentials.Token,
+		RefreshToken: credentials.RefreshToken,
+		ExpiresAt:    credentials.ExpiresAt,
+	}
+}
+
+// TokenResponse is a device the user is logged in on, the secret is never shown again
+type TokenResponse struct {
+	Id         string    `json:"id" example:"string"`
+	Device     string    `json:"device" example:"Pixel 7"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current" example:"true"`
+}
+
+type authClaimResponse struct {
+	DefaultResponse
+}
+
+type authVerifyResponse struct {
+	DefaultResponse
+}
+
+type authForgotResponse struct {
+	DefaultResponse
+}
+
+type authResetResponse struct {
+	DefaultResponse
+}
+
+type authSessionsResponse struct {
+	Sessions []TokenResponse `json:"sessions"`
+	DefaultResponse
+}
+
+func NewAuthSessionsResponse(tokens []core.Token, current_id string) *authSessionsResponse {
+	sessions := make([]TokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, TokenResponse{
+			Id:         token.Id,
+			Device:     token.Device,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+			Current:    token.Id == current_id,
+		})
+	}
+	return &authSessionsResponse{
+		Sessions: sessions,
+	}
+}
+
+// RoomEffect is what laying a card of a rank does to the next players
+type RoomEffect struct {
+	Pull    int  `json:"pull" example:"2"`
+	Skip    int  `json:"skip" example:"1"`
+	Reverse bool `json:"reverse" example:"false"`
+}
+
+type RoomSettingsResponse struct {
+	MustLay       []string              `json:"must_lay" example:"6"`
+	MustLayOrPull []string              `json:"must_lay_or_pull" example:"8"`
+	Stack         []string              `json:"stack" example:"8"`
+	Effects       map[string]RoomEffect `json:"effects"`
+	DeckSize      int                   `json:"deck_size" example:"36"`
+	DealerHand    int                   `json:"dealer_hand" example:"5"`
+	PlayerHand    int                   `json:"player_hand" example:"4"`
+}
+
+func NewRoomSettingsResponse(settings *core.RoomSettings) *RoomSettingsResponse {
+	return &RoomSettingsResponse{
+		MustLay:       ranksToStrings(settings.Rules.MustLayRanks),
+		MustLayOrPull: ranksToStrings(settings.Rules.MustLayOrPullRanks),
+		Stack:         ranksToStrings(settings.Rules.StackRanks),
+		Effects:       effectsToStrings(settings.Rules.Effects),
+		DeckSize:      settings.DeckSize,
+		DealerHand:    settings.DealerHand,
+		PlayerHand:    settings.PlayerHand,
+	}
+}
+
 type RoomResponse struct {
-	Id    string               `json:"id" example:"string"`
-	Host  UserResponseSecure   `json:"host" example:UserResponseSecure`
-	Users []UserResponseSecure `json:"users" example:UserResponseSecure`
-	Open  bool                 `json:"open" example:"true"`
+	Id       string               `json:"id" example:"string"`
+	Host     UserResponseSecure   `json:"host"`
+	Users    []UserResponseSecure `json:"users"`
+	Open     bool                 `json:"open" example:"true"`
+	Settings RoomSettingsResponse `json:"settings"`
 }
 
 func NewRoomResponse(room *core.Room, users []core.User) *RoomResponse {
@@ -206,15 +516,16 @@ func NewRoomResponse(room *core.Room, users []core.User) *RoomResponse {
 	}
 
 	return &RoomResponse{
-		Id:    room.Id,
-		Host:  *NewUserResponseSecure(host),
-		Users: users_response,
-		Open:  room.Open,
+		Id:       room.Id,
+		Host:     *NewUserResponseSecure(host),
+		Users:    users_response,
+		Open:     room.Open,
+		Settings: *NewRoomSettingsResponse(&room.Settings),
 	}
 }
 
 type roomGetResponse struct {
-	Room RoomResponse `json:"room" example:RoomResponse`
+	Room RoomResponse `json:"room"`
 	DefaultResponse
 }
 
@@ -229,7 +540,7 @@ type RoomUser struct {
 }
 
 type roomListResponse struct {
-	Rooms []RoomResponse `json:"rooms" example:RoomResponse`
+	Rooms []RoomResponse `json:"rooms"`
 	DefaultResponse
 }
 
@@ -243,11 +554,65 @@ func NewRoomListResponse(roomUsers []RoomUser) *roomListResponse {
 	}
 }
 
+type RoundResponse struct {
+	SessionId string         `json:"session_id" example:"string"`
+	Dealer    string         `json:"dealer" example:"string"`
+	Scores    map[string]int `json:"scores"`
+}
+
+type MatchResponse struct {
+	Id        string          `json:"id" example:"string"`
+	RoomId    string          `json:"room_id" example:"string"`
+	Players   []string        `json:"players" example:"string"`
+	Limit     int             `json:"limit" example:"125"`
+	SessionId string          `json:"session_id" example:"string"`
+	Rounds    []RoundResponse `json:"rounds"`
+	Scores    map[string]int  `json:"scores"`
+	Finished  bool            `json:"finished" example:"false"`
+}
+
+func NewMatchResponse(match *core.Match) *MatchResponse {
+	rounds := make([]RoundResponse, 0, len(match.Rounds))
+	for _, round := range match.Rounds {
+		rounds = append(rounds, RoundResponse{
+			SessionId: round.SessionId,
+			Dealer:    round.Dealer,
+			Scores:    round.Scores,
+		})
+	}
+	var session_id string
+	if round := match.CurrentRound(); round != nil && !match.Finished {
+		session_id = round.SessionId
+	}
+	return &MatchResponse{
+		Id:        match.Id,
+		RoomId:    match.RoomId,
+		Players:   match.Players,
+		Limit:     match.Limit,
+		SessionId: session_id,
+		Rounds:    rounds,
+		Scores:    match.Scores(),
+		Finished:  match.Finished,
+	}
+}
+
+type matchGetResponse struct {
+	Match MatchResponse `json:"match"`
+	DefaultResponse
+}
+
+type matchCreateResponse struct {
+	MatchId string `json:"match_id" example:"string"`
+	DefaultResponse
+}
+
 type ErrResponse struct {
 	Code int `json:"-"`
 
 	Success bool   `json:"success" example:"false"`
 	Message string `json:"message,omitempty" example:"Error occured"`
+	// Fields tells what is wrong with each invalid field of the request
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 func (er ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -256,6 +621,8 @@ func (er ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// AuthRequest holds the credentials of the deprecated legacy auth,
+// AuthMiddleware reads them from the query or the body on its own
 type AuthRequest struct {
 	UserId string `json:"user_id" example:"string"`
 	Token  string `json:"token" example:"string"`
@@ -277,3 +644,51 @@ func (resp *DefaultResponse) Render(w http.ResponseWriter, r *http.Request) erro
 	resp.Success = true
 	return nil
 }
+
+func ranksToStrings(ranks []deck.Rank) []string {
+	result := make([]string, 0, len(ranks))
+	for _, rank := range ranks {
+		result = append(result, repositories.RankToString(rank))
+	}
+	return result
+}
+
+func effectsToStrings(effects map[deck.Rank]rules.Effect) map[string]RoomEffect {
+	result := make(map[string]RoomEffect, len(effects))
+	for rank, effect := range effects {
+		result[repositories.RankToString(rank)] = RoomEffect{
+			Pull:    effect.Pull,
+			Skip:    effect.Skip,
+			Reverse: effect.Reverse,
+		}
+	}
+	return result
+}
+
+func stringsToEffects(effects map[string]RoomEffect) (map[deck.Rank]rules.Effect, error) {
+	result := make(map[deck.Rank]rules.Effect, len(effects))
+	for s, effect := range effects {
+		rank, err := repositories.StringToRank(s)
+		if err != nil {
+			return nil, err
+		}
+		result[rank] = rules.Effect{
+			Pull:    effect.Pull,
+			Skip:    effect.Skip,
+			Reverse: effect.Reverse,
+		}
+	}
+	return result, nil
+}
+
+func stringsToRanks(ranks []string) ([]deck.Rank, error) {
+	result := make([]deck.Rank, 0, len(ranks))
+	for _, s := range ranks {
+		rank, err := repositories.StringToRank(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rank)
+	}
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/MrBTTF/gophercises/deck"
+	"github.com/mrbttf/bridge-server/pkg/core"
+	"github.com/mrbttf/bridge-server/pkg/core/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionResponseHidesCards(t *testing.T) {
+	session := &core.Session{
+		Id:      "session",
+		Players: []string{"me", "other"},
+		Deck: []core.Card{
+			core.NewCard(deck.Spade, deck.Ace),
+			core.NewCard(deck.Spade, deck.King),
+		},
+		Table: []core.Card{
+			core.NewCard(deck.Heart, deck.Nine),
+			core.NewCard(deck.Heart, deck.Ten),
+		},
+		CurrentPlayer: "other",
+	}
+	players := []core.Player{
+		{Id: "me", Cards: []core.Card{core.NewCard(deck.Club, deck.Six)}, State: state.StateWaitForTurn},
+		{Id: "other", Cards: []core.Card{
+			core.NewCard(deck.Club, deck.Seven),
+			core.NewCard(deck.Club, deck.Eight),
+			core.NewCard(deck.Club, deck.Nine),
+		}, State: state.StateCanLay},
+	}
+
+	response := NewSessionResponse(session, "me", players)
+
+	assert.Equal(t, 2, response.DeckSize)
+	assert.Equal(t, "HT", response.TableTop)
+	assert.Equal(t, "me", response.Player.Id)
+	assert.Equal(t, []string{"C6"}, response.Player.Cards)
+	assert.Equal(t, []OpponentResponse{{
+		Id:       "other",
+		HandSize: 3,
+		State:    state.StateCanLay.String(),
+	}}, response.Opponents)
+	assert.Equal(t, "other", response.CurrentPlayer)
+}
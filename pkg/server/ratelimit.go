@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/log"
+	"github.com/mrbttf/bridge-server/pkg/ratelimit"
+)
+
+var (
+	ErrServerTooManyRequests = errors.New("Too many requests")
+	ErrServerLoginLocked     = errors.New("Too many failed logins")
+)
+
+// Rate limits of the routes, keyed by client IP before authentication
+// and by user after it
+var (
+	registerLimit = ratelimit.Limit{Requests: 10, Window: time.Hour}
+	guestLimit    = ratelimit.Limit{Requests: 10, Window: time.Hour}
+	loginLimit    = ratelimit.Limit{Requests: 30, Window: time.Minute}
+	refreshLimit  = ratelimit.Limit{Requests: 30, Window: time.Minute}
+	verifyLimit   = ratelimit.Limit{Requests: 30, Window: time.Minute}
+	resetLimit    = ratelimit.Limit{Requests: 30, Window: time.Minute}
+	// forgotLimit and resendLimit send emails, which must not be a way to flood a mailbox
+	forgotLimit = ratelimit.Limit{Requests: 5, Window: time.Hour}
+	resendLimit = ratelimit.Limit{Requests: 5, Window: time.Hour}
+	// loginEmailLimit limits the attempts on one account, wherever they come from
+	loginEmailLimit = ratelimit.Limit{Requests: 10, Window: time.Minute}
+	// gameLimit is per game action, a client has no reason to act more often
+	gameLimit = ratelimit.Limit{Requests: 10, Window: time.Second}
+)
+
+var (
+	// emailLockout locks an account after wrong passwords
+	emailLockout = ratelimit.Lockout{Threshold: 5, Base: time.Minute, Max: time.Hour, Memory: 24 * time.Hour}
+	// ipLockout locks a client guessing across accounts,
+	// it is laxer as clients behind one NAT share an IP
+	ipLockout = ratelimit.Lockout{Threshold: 20, Base: time.Minute, Max: time.Hour, Memory: 24 * time.Hour}
+)
+
+// rateKey tells whose requests are counted together
+type rateKey func(r *http.Request) string
+
+func byIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// byUser needs AuthMiddleware to run first
+func byUser(r *http.Request) string {
+	return "user:" + requestUserId(r)
+}
+
+// RateLimit answers 429 with Retry-After to the requests to route
+// that are over the limit for their key
+func (s *Server) RateLimit(route string, limit ratelimit.Limit, key rateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := s.limiter.Allow(route+":"+key(r), limit)
+			if err != nil {
+				// the store being down must not take the server down with it
+				log.Error(err)
+			} else if !ok {
+				renderTooManyRequests(w, r, retryAfter, ErrServerTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loginKeys are the keys failed logins for email are counted under
+func loginKeys(r *http.Request, email string) (ip string, account string) {
+	return "login:" + byIP(r), "login:email:" + email
+}
+
+// checkLogin tells how long logins for email are locked or limited,
+// zero if the login may go on
+func (s *Server) checkLogin(r *http.Request, email string) (time.Duration, error) {
+	ip, account := loginKeys(r, email)
+	for _, key := range []string{ip, account} {
+		locked, err := s.limiter.Locked(key)
+		if err != nil || locked > 0 {
+			return locked, err
+		}
+	}
+	ok, retryAfter, err := s.limiter.Allow(account, loginEmailLimit)
+	if err != nil || ok {
+		return 0, err
+	}
+	return retryAfter, nil
+}
+
+// loginFailed counts a wrong password, which may lock the next logins
+func (s *Server) loginFailed(r *http.Request, email string) {
+	ip, account := loginKeys(r, email)
+	for key, lockout := range map[string]ratelimit.Lockout{ip: ipLockout, account: emailLockout} {
+		_, err := s.limiter.Fail(key, lockout)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// loginSucceeded forgets the wrong passwords for email, but not those from the client,
+// who could otherwise guess other accounts in between logging into its own
+func (s *Server) loginSucceeded(r *http.Request, email string) {
+	_, account := loginKeys(r, email)
+	err := s.limiter.Succeed(account)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func renderTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message error) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	renderError(w, r, http.StatusTooManyRequests, message, nil)
+}
+
+// clientIP is the address of the client, as the proxy tells it if the server trusts it
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -4,12 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
 	"github.com/mrbttf/bridge-server/pkg/config"
 	"github.com/mrbttf/bridge-server/pkg/core"
 	"github.com/mrbttf/bridge-server/pkg/log"
+	"github.com/mrbttf/bridge-server/pkg/ratelimit"
 	"github.com/mrbttf/bridge-server/pkg/repositories"
 	httpSwagger "github.com/swaggo/http-swagger"
 
@@ -20,16 +24,26 @@ var (
 	ErrServerBadRequest = errors.New("Bad request occured")
 	ErrServerInternal   = errors.New("Internal server error")
 	ErrServerForbidden  = errors.New("Forbidden")
+	ErrServerValidation = errors.New("Validation failed")
 
 	ErrServerSessionIdInvalid  = errors.New("session_id parameter is invalid")
 	ErrServerSessionIdNotFound = errors.New("Session ID not found")
+	ErrServerNotInSession      = errors.New("User does not play in the session")
 
 	ErrServerRoomIdInvalid  = errors.New("room_id parameter is invalid")
 	ErrServerRoomIdNotFound = errors.New("Room ID not found")
+	ErrServerNotHost        = errors.New("Only the host can change the room")
+
+	ErrServerMatchIdInvalid  = errors.New("match_id parameter is invalid")
+	ErrServerMatchIdNotFound = errors.New("Match ID not found")
 
 	ErrServerUserIdInvalid  = errors.New("user_id parameter is invalid")
 	ErrServerUserIdNotFound = errors.New("User ID not found")
 	ErrServerUserNoSession  = errors.New("User has no session")
+
+	ErrServerTokenIdNotFound = errors.New("Token ID not found")
+	ErrServerTokenInvalid    = errors.New("Token is invalid or has expired")
+	ErrServerNotGuest        = errors.New("User is not a guest")
 )
 
 type Server struct {
@@ -37,12 +51,24 @@ type Server struct {
 	sessionService core.SessionServicePort
 	roomService    core.RoomServicePort
 	authService    core.AuthServicePort
+	matchService   core.MatchServicePort
+	policy         core.PolicyPort
+	sessionEvents  core.SessionEventSubscriber
+	roomEvents     core.RoomEventSubscriber
+	limiter        *ratelimit.Limiter
+	upgrader       *websocket.Upgrader
+	legacyAuth     bool
 }
 
 func New(
 	sessionService core.SessionServicePort,
 	roomService core.RoomServicePort,
 	authService core.AuthServicePort,
+	matchService core.MatchServicePort,
+	policy core.PolicyPort,
+	sessionEvents core.SessionEventSubscriber,
+	roomEvents core.RoomEventSubscriber,
+	rateLimits ratelimit.Store,
 	config config.Config,
 ) *Server {
 	s := &Server{
@@ -50,27 +76,55 @@ func New(
 		sessionService: sessionService,
 		authService:    authService,
 		roomService:    roomService,
+		matchService:   matchService,
+		policy:         policy,
+		sessionEvents:  sessionEvents,
+		roomEvents:     roomEvents,
+		limiter:        ratelimit.New(rateLimits),
+		upgrader:       newUpgrader(config.AppURL, config.AllowedOrigins),
+		legacyAuth:     config.LegacyAuth,
 	}
 
+	if config.TrustProxy {
+		s.router.Use(middleware.RealIP)
+	}
 	s.router.Use(render.SetContentType(render.ContentTypeJSON))
 
 	s.router.With(s.AuthMiddleware).Get("/session/{session_id}", s.sessionGet)
+	s.router.With(BrowserAuth, s.AuthMiddleware).Get("/session/{session_id}/ws", s.sessionWebSocket)
 	s.router.With(s.AuthMiddleware).Post("/session/getByUser", s.sessionGetByUser)
 	s.router.With(s.AuthMiddleware).Post("/session/create", s.sessionCreate)
-	s.router.With(s.AuthMiddleware).Post("/session/lay", s.sessionLay)
-	s.router.With(s.AuthMiddleware).Post("/session/pull", s.sessionPull)
-	s.router.With(s.AuthMiddleware).Post("/session/nextTurn", s.sessionNextTurn)
+	s.router.With(s.AuthMiddleware, s.RateLimit("lay", gameLimit, byUser)).Post("/session/lay", s.sessionLay)
+	s.router.With(s.AuthMiddleware, s.RateLimit("pull", gameLimit, byUser)).Post("/session/pull", s.sessionPull)
+	s.router.With(s.AuthMiddleware, s.RateLimit("bridge", gameLimit, byUser)).Post("/session/bridge", s.sessionBridge)
+	s.router.With(s.AuthMiddleware, s.RateLimit("nextTurn", gameLimit, byUser)).Post("/session/nextTurn", s.sessionNextTurn)
 	s.router.With(s.AuthMiddleware).Post("/session/close", s.sessionClose)
 
+	s.router.With(BrowserAuth, s.AuthMiddleware).Get("/room/events", s.roomEventStream)
 	s.router.With(s.AuthMiddleware).Get("/room/{room_id}", s.roomGet)
 	s.router.With(s.AuthMiddleware).Post("/room/create", s.roomCreate)
 	s.router.With(s.AuthMiddleware).Post("/room/list", s.roomList)
 	s.router.With(s.AuthMiddleware).Post("/room/join", s.roomJoin)
+	s.router.With(s.AuthMiddleware).Post("/room/leave", s.roomLeave)
+	s.router.With(s.AuthMiddleware).Post("/room/settings", s.roomSettings)
 	s.router.With(s.AuthMiddleware).Post("/room/delete", s.roomDelete)
 
-	s.router.Post("/auth/register", s.authRegister)
-	s.router.Post("/auth/login", s.authLogin)
-	s.router.Post("/auth/logout", s.authLogout)
+	s.router.With(s.AuthMiddleware).Get("/match/{match_id}", s.matchGet)
+	s.router.With(s.AuthMiddleware).Post("/match/create", s.matchCreate)
+	s.router.With(s.AuthMiddleware).Post("/match/nextRound", s.matchNextRound)
+
+	s.router.With(s.RateLimit("register", registerLimit, byIP)).Post("/auth/register", s.authRegister)
+	s.router.With(s.RateLimit("login", loginLimit, byIP)).Post("/auth/login", s.authLogin)
+	s.router.With(s.RateLimit("guest", guestLimit, byIP)).Post("/auth/guest", s.authGuest)
+	s.router.With(s.AuthMiddleware).Post("/auth/claim", s.authClaim)
+	s.router.With(s.RateLimit("refresh", refreshLimit, byIP)).Post("/auth/refresh", s.authRefresh)
+	s.router.With(s.RateLimit("verify", verifyLimit, byIP)).Post("/auth/verify", s.authVerify)
+	s.router.With(s.AuthMiddleware, s.RateLimit("resend", resendLimit, byUser)).Post("/auth/verify/resend", s.authVerifyResend)
+	s.router.With(s.RateLimit("forgot", forgotLimit, byIP)).Post("/auth/forgot", s.authForgot)
+	s.router.With(s.RateLimit("reset", resetLimit, byIP)).Post("/auth/reset", s.authReset)
+	s.router.With(s.AuthMiddleware).Post("/auth/logout", s.authLogout)
+	s.router.With(s.AuthMiddleware).Post("/auth/logoutAll", s.authLogoutAll)
+	s.router.With(s.AuthMiddleware).Get("/auth/sessions", s.authSessions)
 
 	s.router.Get("/health", s.health)
 	s.router.Get("/docs/*", httpSwagger.WrapHandler)
@@ -89,14 +143,14 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 
 // session/ godoc
 // @Summary Get session
-// @Description Gets game session for session_id
+// @Description Gets game session for session_id as user_id sees it: own cards, hand sizes of the opponents, deck size and the top of the table
 // @Tags session
 // @Produce  json
 // @Param session_id path string true "ID of session"
-// @Param token query string true "token"
-// @Param user_id query string true "user_id"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} sessionGetResponse
-// @Failure 500 {object} ErrResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
 // @Router /session/{session_id} [get]
 func (s *Server) sessionGet(w http.ResponseWriter, r *http.Request) {
 	sessionId := chi.URLParam(r, "session_id")
@@ -110,12 +164,21 @@ func (s *Server) sessionGet(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusNotFound, ErrServerSessionIdNotFound, err)
 		return
 	}
-	player, err := s.sessionService.GetPlayer(session.CurrentPlayer)
-	if err != nil {
-		renderError(w, r, http.StatusNotFound, ErrServerSessionIdNotFound, err)
+	viewerId := requestUserId(r)
+	if !session.HasPlayer(viewerId) {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, ErrServerNotInSession)
 		return
 	}
-	response := NewSessionResponse(&session, &player)
+	players := make([]core.Player, 0, len(session.Players))
+	for _, player_id := range session.Players {
+		player, err := s.sessionService.GetPlayer(player_id)
+		if err != nil {
+			renderError(w, r, http.StatusNotFound, ErrServerSessionIdNotFound, err)
+			return
+		}
+		players = append(players, player)
+	}
+	response := NewSessionResponse(&session, viewerId, players)
 
 	render.Render(w, r, &sessionGetResponse{
 		Session: *response,
@@ -128,6 +191,7 @@ func (s *Server) sessionGet(w http.ResponseWriter, r *http.Request) {
 // @Tags session
 // @Produce  json
 // @Param session_body body sessionGetByUserRequest true "body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} sessionGetByUserResponse
 // @Failure 204 {object} sessionNoSessionErrorResponse
 // @Failure 500 {object} ErrResponse
@@ -140,7 +204,12 @@ func (s *Server) sessionGetByUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	player, err := s.sessionService.GetPlayer(data.UserId)
+	userId, err := actingUser(r, data.UserId)
+	if err != nil {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	player, err := s.sessionService.GetPlayer(userId)
 	if err != nil {
 		renderError(w, r, http.StatusNotFound, ErrServerUserIdNotFound, err)
 		return
@@ -158,12 +227,16 @@ func (s *Server) sessionGetByUser(w http.ResponseWriter, r *http.Request) {
 
 // session/create godoc
 // @Summary Creates session
-// @Description Creates a game session and returns its id
+// @Description Creates a game session for the room and returns its id, only users in the room can do it
 // @Tags session
 // @Accept   json
 // @Produce  json
 // @Param session_body body sessionCreateRequest true "body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} sessionCreateResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /session/create [post]
 func (s *Server) sessionCreate(w http.ResponseWriter, r *http.Request) {
@@ -173,15 +246,14 @@ func (s *Server) sessionCreate(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	session_id, err := s.sessionService.Create(data.RoomId, nil)
+	err := s.policy.CanCreateSession(requestUserId(r), data.RoomId)
 	if err != nil {
-		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		renderPolicyError(w, r, ErrServerRoomIdNotFound, err)
 		return
 	}
-
-	err = s.roomService.Close(data.RoomId)
+	session_id, err := s.sessionService.Create(data.RoomId, "", nil)
 	if err != nil {
-		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		renderGameError(w, r, err)
 		return
 	}
 	render.Render(w, r, &sessionCreateResponse{
@@ -191,12 +263,16 @@ func (s *Server) sessionCreate(w http.ResponseWriter, r *http.Request) {
 
 // session/lay godoc
 // @Summary Lays a card
-// @Description Lays a card for player and session id
+// @Description Lays a card for player and session id, a Jack may demand the next suit
 // @Tags session
 // @Accept   json
 // @Produce  json
 // @Param body body sessionLayRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /session/lay [post]
 func (s *Server) sessionLay(w http.ResponseWriter, r *http.Request) {
@@ -207,9 +283,23 @@ func (s *Server) sessionLay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	card := repositories.StringToCard(data.Card)
-	err := s.sessionService.Lay(data.SessionId, data.PlayerId, card)
+	var suit *core.Suit
+	if data.Suit != "" {
+		demanded, err := repositories.StringToSuit(data.Suit)
+		if err != nil {
+			renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+			return
+		}
+		suit = &demanded
+	}
+	playerId, err := actingUser(r, data.PlayerId)
 	if err != nil {
-		renderError(w, r, http.StatusInternalServerError, err, err)
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	err = s.sessionService.Lay(data.SessionId, playerId, card, suit)
+	if err != nil {
+		renderGameError(w, r, err)
 		return
 	}
 	render.Render(w, r, &DefaultResponse{})
@@ -222,7 +312,11 @@ func (s *Server) sessionLay(w http.ResponseWriter, r *http.Request) {
 // @Accept   json
 // @Produce  json
 // @Param body body sessionPullRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /session/pull [post]
 func (s *Server) sessionPull(w http.ResponseWriter, r *http.Request) {
@@ -232,9 +326,48 @@ func (s *Server) sessionPull(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	err := s.sessionService.Pull(data.SessionId, data.PlayerId)
+	playerId, err := actingUser(r, data.PlayerId)
 	if err != nil {
-		renderError(w, r, http.StatusInternalServerError, err, err)
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	err = s.sessionService.Pull(data.SessionId, playerId)
+	if err != nil {
+		renderGameError(w, r, err)
+		return
+	}
+	render.Render(w, r, &DefaultResponse{})
+}
+
+// session/bridge godoc
+// @Summary Declares a bridge
+// @Description Declares or declines the bridge the player made on the table, declaring ends the round
+// @Tags session
+// @Accept   json
+// @Produce  json
+// @Param body body sessionBridgeRequest true "Body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /session/bridge [post]
+func (s *Server) sessionBridge(w http.ResponseWriter, r *http.Request) {
+	data := &sessionBridgeRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	playerId, err := actingUser(r, data.PlayerId)
+	if err != nil {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	err = s.sessionService.Bridge(data.SessionId, playerId, data.Declare)
+	if err != nil {
+		renderGameError(w, r, err)
 		return
 	}
 	render.Render(w, r, &DefaultResponse{})
@@ -242,12 +375,16 @@ func (s *Server) sessionPull(w http.ResponseWriter, r *http.Request) {
 
 // session/nextTurn godoc
 // @Summary Next turn
-// @Description Ends turn for player id and passes turn to next player
+// @Description Ends turn for player id and passes turn to the next player in the direction of play
 // @Tags session
 // @Accept   json
 // @Produce  json
 // @Param body body sessionNextTurnRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /session/nextTurn [post]
 func (s *Server) sessionNextTurn(w http.ResponseWriter, r *http.Request) {
@@ -257,9 +394,14 @@ func (s *Server) sessionNextTurn(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	err := s.sessionService.NextTurn(data.SessionId, data.PlayerId)
+	playerId, err := actingUser(r, data.PlayerId)
 	if err != nil {
-		renderError(w, r, http.StatusInternalServerError, err, err)
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	err = s.sessionService.NextTurn(data.SessionId, playerId)
+	if err != nil {
+		renderGameError(w, r, err)
 		return
 	}
 	render.Render(w, r, &DefaultResponse{})
@@ -267,12 +409,15 @@ func (s *Server) sessionNextTurn(w http.ResponseWriter, r *http.Request) {
 
 // session/close godoc
 // @Summary Closes session
-// @Description Deletes a session and its players
+// @Description Deletes a session and its players, only its players can do it
 // @Tags session
 // @Accept   json
 // @Produce  json
 // @Param body body sessionCloseRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /session/close [post]
 func (s *Server) sessionClose(w http.ResponseWriter, r *http.Request) {
@@ -282,7 +427,12 @@ func (s *Server) sessionClose(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	err := s.sessionService.DeleteSession(data.SessionId)
+	err := s.policy.CanCloseSession(requestUserId(r), data.SessionId)
+	if err != nil {
+		renderPolicyError(w, r, ErrServerSessionIdNotFound, err)
+		return
+	}
+	err = s.sessionService.DeleteSession(data.SessionId)
 	if err != nil {
 		renderError(w, r, http.StatusInternalServerError, err, err)
 		return
@@ -296,8 +446,7 @@ func (s *Server) sessionClose(w http.ResponseWriter, r *http.Request) {
 // @Tags room
 // @Produce  json
 // @Param room_id path string true "ID of room"
-// @Param token query string true "token"
-// @Param user_id query string true "user_id"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} roomGetResponse
 // @Failure 500 {object} ErrResponse
 // @Router /room/{room_id} [get]
@@ -332,6 +481,7 @@ func (s *Server) roomGet(w http.ResponseWriter, r *http.Request) {
 // @Accept   json
 // @Produce  json
 // @Param room_body body roomCreateRequest true "body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} roomCreateResponse
 // @Failure 500 {object} ErrResponse
 // @Router /room/create [post]
@@ -342,7 +492,12 @@ func (s *Server) roomCreate(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	room_id, err := s.roomService.Create(data.HostId)
+	hostId, err := actingUser(r, data.HostId)
+	if err != nil {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	room_id, err := s.roomService.Create(hostId)
 	if err != nil {
 		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
 		return
@@ -359,6 +514,7 @@ func (s *Server) roomCreate(w http.ResponseWriter, r *http.Request) {
 // @Accept   json
 // @Produce  json
 // @Param body body roomJoinRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
 // @Failure 500 {object} ErrResponse
 // @Router /room/join [post]
@@ -369,7 +525,38 @@ func (s *Server) roomJoin(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	err := s.roomService.Join(data.RoomId, data.UserId)
+	userId, err := actingUser(r, data.UserId)
+	if err != nil {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	err = s.roomService.Join(data.RoomId, userId)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, err, err)
+		return
+	}
+	render.Render(w, r, &DefaultResponse{})
+}
+
+// room/leave godoc
+// @Summary Leaves room
+// @Description Takes the user out of the room, the next user becomes host if the host leaves and an empty room is deleted
+// @Tags room
+// @Accept   json
+// @Produce  json
+// @Param body body roomLeaveRequest true "Body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} DefaultResponse
+// @Failure 500 {object} ErrResponse
+// @Router /room/leave [post]
+func (s *Server) roomLeave(w http.ResponseWriter, r *http.Request) {
+	data := &roomLeaveRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.roomService.Leave(data.RoomId, requestUserId(r))
 	if err != nil {
 		renderError(w, r, http.StatusInternalServerError, err, err)
 		return
@@ -384,6 +571,7 @@ func (s *Server) roomJoin(w http.ResponseWriter, r *http.Request) {
 // @Accept   json
 // @Produce  json
 // @Param body body roomListRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} roomListResponse
 // @Failure 500 {object} ErrResponse
 // @Router /room/list [post]
@@ -414,14 +602,61 @@ func (s *Server) roomList(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, NewRoomListResponse(roomUsers))
 }
 
+// room/settings godoc
+// @Summary Changes room settings
+// @Description Changes the house rules of the room, only the host can do it
+// @Tags room
+// @Accept   json
+// @Produce  json
+// @Param body body roomSettingsRequest true "Body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /room/settings [post]
+func (s *Server) roomSettings(w http.ResponseWriter, r *http.Request) {
+	data := &roomSettingsRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	room, err := s.roomService.Get(data.RoomId)
+	if err != nil {
+		renderError(w, r, http.StatusNotFound, ErrServerRoomIdNotFound, err)
+		return
+	}
+	settings, err := data.Settings(room.Settings)
+	if err == nil {
+		err = s.roomService.UpdateSettings(data.RoomId, requestUserId(r), settings)
+	}
+	var invalid *core.ValidationError
+	if errors.As(err, &invalid) {
+		renderValidationError(w, r, invalid)
+		return
+	} else if errors.Is(err, core.NotHostError) {
+		renderError(w, r, http.StatusForbidden, ErrServerNotHost, err)
+		return
+	} else if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
+	render.Render(w, r, &DefaultResponse{})
+}
+
 // room/delete godoc
 // @Summary Deletes room
-// @Description Deletes room
+// @Description Deletes room, only its host can do it
 // @Tags room
 // @Accept   json
 // @Produce  json
 // @Param body body roomDeleteRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /room/delete [post]
 func (s *Server) roomDelete(w http.ResponseWriter, r *http.Request) {
@@ -431,9 +666,12 @@ func (s *Server) roomDelete(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
 		return
 	}
-	fmt.Println("roomDelete")
-	fmt.Println(data)
-	err := s.roomService.Delete(data.RoomId)
+	err := s.policy.CanDeleteRoom(requestUserId(r), data.RoomId)
+	if err != nil {
+		renderPolicyError(w, r, ErrServerRoomIdNotFound, err)
+		return
+	}
+	err = s.roomService.Delete(data.RoomId)
 	if err != nil {
 		renderError(w, r, http.StatusInternalServerError, err, err)
 		return
@@ -441,14 +679,122 @@ func (s *Server) roomDelete(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, &DefaultResponse{})
 }
 
+// match/ godoc
+// @Summary Get match
+// @Description Gets match for match_id with per-round and cumulative scores, only its players and the host of its room can do it
+// @Tags match
+// @Produce  json
+// @Param match_id path string true "ID of match"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} matchGetResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Router /match/{match_id} [get]
+func (s *Server) matchGet(w http.ResponseWriter, r *http.Request) {
+	matchId := chi.URLParam(r, "match_id")
+	if matchId == "" {
+		renderError(w, r, http.StatusBadRequest, ErrServerMatchIdInvalid, ErrServerMatchIdInvalid)
+		return
+	}
+	err := s.policy.CanPlayMatch(requestUserId(r), matchId)
+	if err != nil {
+		renderPolicyError(w, r, ErrServerMatchIdNotFound, err)
+		return
+	}
+
+	match, err := s.matchService.Get(matchId)
+	if err != nil {
+		renderError(w, r, http.StatusNotFound, ErrServerMatchIdNotFound, err)
+		return
+	}
+
+	render.Render(w, r, &matchGetResponse{
+		Match: *NewMatchResponse(&match),
+	})
+}
+
+// match/create godoc
+// @Summary Creates match
+// @Description Creates a match played to a score limit in the room and deals its first round, only users in the room can do it
+// @Tags match
+// @Accept   json
+// @Produce  json
+// @Param match_body body matchCreateRequest true "body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} matchCreateResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /match/create [post]
+func (s *Server) matchCreate(w http.ResponseWriter, r *http.Request) {
+	data := &matchCreateRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.policy.CanCreateSession(requestUserId(r), data.RoomId)
+	if err != nil {
+		renderPolicyError(w, r, ErrServerRoomIdNotFound, err)
+		return
+	}
+	match_id, err := s.matchService.Create(data.RoomId, data.Limit)
+	if err != nil {
+		renderGameError(w, r, err)
+		return
+	}
+	render.Render(w, r, &matchCreateResponse{
+		MatchId: match_id,
+	})
+}
+
+// match/nextRound godoc
+// @Summary Next round
+// @Description Scores the finished round and deals the next one, only the players of the match and the host of its room can do it
+// @Tags match
+// @Accept   json
+// @Produce  json
+// @Param body body matchNextRoundRequest true "Body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} DefaultResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /match/nextRound [post]
+func (s *Server) matchNextRound(w http.ResponseWriter, r *http.Request) {
+	data := &matchNextRoundRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.policy.CanPlayMatch(requestUserId(r), data.MatchId)
+	if err != nil {
+		renderPolicyError(w, r, ErrServerMatchIdNotFound, err)
+		return
+	}
+	err = s.matchService.NextRound(data.MatchId)
+	if err != nil {
+		renderGameError(w, r, err)
+		return
+	}
+	render.Render(w, r, &DefaultResponse{})
+}
+
 // auth/register godoc
 // @Summary Registers user
-// @Description Registers user
+// @Description Registers user. The email and the nickname must be free, ignoring case.
+// @Description The nickname has 3 to 20 letters, digits, '_', '-' or '.', the password at least 8 characters.
+// @Description A link to verify the email is mailed to it, the user is registered even if that fails.
 // @Tags auth
 // @Accept   json
 // @Produce  json
 // @Param register_body body authRegisterRequest true "Body"
 // @Success 200 {object} authRegisterResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 429 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /auth/register [post]
 func (s *Server) authRegister(w http.ResponseWriter, r *http.Request) {
@@ -463,7 +809,15 @@ func (s *Server) authRegister(w http.ResponseWriter, r *http.Request) {
 		data.Password,
 		data.Nickname,
 	)
-	if err != nil {
+	if errors.Is(err, core.MailNotSentError) {
+		log.Warn(err)
+		err = nil
+	}
+	var invalid *core.ValidationError
+	if errors.As(err, &invalid) {
+		renderValidationError(w, r, invalid)
+		return
+	} else if err != nil {
 		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
 		return
 	}
@@ -478,6 +832,8 @@ func (s *Server) authRegister(w http.ResponseWriter, r *http.Request) {
 // @Produce  json
 // @Param login_body body authLoginRequest true "Body"
 // @Success 200 {object} authLoginResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 429 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /auth/login [post]
 func (s *Server) authLogin(w http.ResponseWriter, r *http.Request) {
@@ -487,28 +843,260 @@ func (s *Server) authLogin(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
 		return
 	}
-	fmt.Printf("%+v\n", data)
-	user, err := s.authService.Login(
+	email := strings.ToLower(data.Email)
+	retryAfter, err := s.checkLogin(r, email)
+	if err != nil {
+		log.Error(err)
+	} else if retryAfter > 0 {
+		renderTooManyRequests(w, r, retryAfter, ErrServerLoginLocked)
+		return
+	}
+
+	device := data.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+	user, credentials, err := s.authService.Login(
 		data.Email,
 		data.Password,
+		device,
 	)
+	if errors.Is(err, core.LoginInvalidError) {
+		s.loginFailed(r, email)
+	}
 	if err != nil {
 		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
 		return
 	}
+	s.loginSucceeded(r, email)
+	render.Render(w, r, &authLoginResponse{
+		User: *NewUserResponse(&user, credentials),
+	})
+}
+
+// auth/guest godoc
+// @Summary Logs guest in
+// @Description Creates a guest with a generated nickname and no email or password and logs it in.
+// @Description A guest plays like any user, it is deleted after a week without using its token unless it claims the account.
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param guest_body body authGuestRequest true "Body"
+// @Success 200 {object} authLoginResponse
+// @Failure 429 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /auth/guest [post]
+func (s *Server) authGuest(w http.ResponseWriter, r *http.Request) {
+	data := &authGuestRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	device := data.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+	user, credentials, err := s.authService.LoginGuest(device)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
 	render.Render(w, r, &authLoginResponse{
-		User: *NewUserResponse(&user),
+		User: *NewUserResponse(&user, credentials),
 	})
 }
 
+// auth/claim godoc
+// @Summary Claims guest account
+// @Description Registers the guest with email, password and, unless empty, nickname, checked as for auth/register.
+// @Description The user keeps its id, rooms, games and logins. A link to verify the email is mailed to it.
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param claim_body body authClaimRequest true "Body"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} authClaimResponse
+// @Failure 409 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /auth/claim [post]
+func (s *Server) authClaim(w http.ResponseWriter, r *http.Request) {
+	data := &authClaimRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.authService.Claim(
+		requestUserId(r),
+		data.Email,
+		data.Password,
+		data.Nickname,
+	)
+	if errors.Is(err, core.MailNotSentError) {
+		log.Warn(err)
+		err = nil
+	}
+	var invalid *core.ValidationError
+	if errors.As(err, &invalid) {
+		renderValidationError(w, r, invalid)
+		return
+	} else if errors.Is(err, core.NotGuestError) {
+		renderError(w, r, http.StatusConflict, ErrServerNotGuest, err)
+		return
+	} else if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
+	render.Render(w, r, &authClaimResponse{})
+}
+
+// auth/refresh godoc
+// @Summary Refreshes access token
+// @Description Exchanges refresh_token for a new access token and a new refresh token, the old one stops working
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param refresh_body body authRefreshRequest true "Body"
+// @Success 200 {object} authRefreshResponse
+// @Failure 401 {object} ErrResponse
+// @Router /auth/refresh [post]
+func (s *Server) authRefresh(w http.ResponseWriter, r *http.Request) {
+	data := &authRefreshRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	credentials, err := s.authService.Refresh(data.RefreshToken)
+	if err != nil {
+		renderError(w, r, http.StatusUnauthorized, ErrServerUnauthorized, err)
+		return
+	}
+	render.Render(w, r, NewAuthRefreshResponse(credentials))
+}
+
+// auth/verify godoc
+// @Summary Verifies email
+// @Description Marks the email as verified with the token of the link mailed to it, a token works once
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param verify_body body authVerifyRequest true "Body"
+// @Success 200 {object} authVerifyResponse
+// @Failure 400 {object} ErrResponse
+// @Failure 429 {object} ErrResponse
+// @Router /auth/verify [post]
+func (s *Server) authVerify(w http.ResponseWriter, r *http.Request) {
+	data := &authVerifyRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.authService.VerifyEmail(data.Token)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerTokenInvalid, err)
+		return
+	}
+	render.Render(w, r, &authVerifyResponse{})
+}
+
+// auth/verify/resend godoc
+// @Summary Resends verification email
+// @Description Mails the user a new link to verify its email, the links sent before stop working
+// @Tags auth
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} authVerifyResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 429 {object} ErrResponse
+// @Failure 500 {object} ErrResponse
+// @Router /auth/verify/resend [post]
+func (s *Server) authVerifyResend(w http.ResponseWriter, r *http.Request) {
+	err := s.authService.SendVerification(requestUserId(r))
+	if errors.Is(err, core.NotAllowedError) {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	} else if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
+	render.Render(w, r, &authVerifyResponse{})
+}
+
+// auth/forgot godoc
+// @Summary Sends password reset email
+// @Description Mails a link to reset the password to the email. It succeeds for any email, so that it does not tell who is registered.
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param forgot_body body authForgotRequest true "Body"
+// @Success 200 {object} authForgotResponse
+// @Failure 429 {object} ErrResponse
+// @Router /auth/forgot [post]
+func (s *Server) authForgot(w http.ResponseWriter, r *http.Request) {
+	data := &authForgotRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	// The link is mailed after answering and a failure is only logged,
+	// so that neither the answer nor how long it takes tells who is registered
+	go func(email string) {
+		err := s.authService.ForgotPassword(email)
+		if err != nil {
+			log.Error(err)
+		}
+	}(data.Email)
+	render.Render(w, r, &authForgotResponse{})
+}
+
+// auth/reset godoc
+// @Summary Resets password
+// @Description Sets the password with the token of the link mailed by auth/forgot and logs the user out on every device.
+// @Description The token works once, it stays usable if the password is invalid.
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param reset_body body authResetRequest true "Body"
+// @Success 200 {object} authResetResponse
+// @Failure 400 {object} ErrResponse
+// @Failure 422 {object} ErrResponse
+// @Failure 429 {object} ErrResponse
+// @Router /auth/reset [post]
+func (s *Server) authReset(w http.ResponseWriter, r *http.Request) {
+	data := &authResetRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerBadRequest, err)
+		return
+	}
+	err := s.authService.ResetPassword(data.Token, data.Password)
+	var invalid *core.ValidationError
+	if errors.As(err, &invalid) {
+		renderValidationError(w, r, invalid)
+		return
+	} else if err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerTokenInvalid, err)
+		return
+	}
+	render.Render(w, r, &authResetResponse{})
+}
+
 // auth/logout godoc
-// @Summary Logs user in
-// @Description Logs user in and returns token
+// @Summary Logs user out
+// @Description Logs user out on the device of token_id or, if it is empty, on the current one.
+// @Description Its refresh token is revoked, an access token works until it expires.
 // @Tags auth
 // @Accept   json
 // @Produce  json
 // @Param logout_body body authLogoutRequest true "Body"
+// @Param Authorization header string true "Bearer token"
 // @Success 200 {object} authLogoutResponse
+// @Failure 404 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Router /auth/logout [post]
 func (s *Server) authLogout(w http.ResponseWriter, r *http.Request) {
@@ -518,17 +1106,111 @@ func (s *Server) authLogout(w http.ResponseWriter, r *http.Request) {
 		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
 		return
 	}
-	err := s.authService.Logout(
-		data.Email,
-		data.Token,
-	)
+	tokenId := data.TokenId
+	if tokenId == "" {
+		tokenId = requestToken(r).Id
+	}
+	err := s.authService.Revoke(requestUserId(r), tokenId)
+	if errors.Is(err, core.TokenNotFoundError) {
+		renderError(w, r, http.StatusNotFound, ErrServerTokenIdNotFound, err)
+		return
+	} else if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
+	render.Render(w, r, &authLogoutResponse{})
+}
+
+// auth/logoutAll godoc
+// @Summary Logs user out everywhere
+// @Description Logs user out on every device, including the current one
+// @Tags auth
+// @Accept   json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} authLogoutResponse
+// @Failure 500 {object} ErrResponse
+// @Router /auth/logoutAll [post]
+func (s *Server) authLogoutAll(w http.ResponseWriter, r *http.Request) {
+	err := s.authService.RevokeAll(requestUserId(r))
 	if err != nil {
-		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
 		return
 	}
 	render.Render(w, r, &authLogoutResponse{})
 }
 
+// auth/sessions godoc
+// @Summary List sessions
+// @Description Lists the devices user_id is logged in on, the current one is marked
+// @Tags auth
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} authSessionsResponse
+// @Failure 500 {object} ErrResponse
+// @Router /auth/sessions [get]
+func (s *Server) authSessions(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.authService.Tokens(requestUserId(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+		return
+	}
+	render.Render(w, r, NewAuthSessionsResponse(tokens, requestToken(r).Id))
+}
+
+// gameErrors are the errors of the game a client can cause, with the status
+// they are answered with. Their text is the message, what they wrap is logged only.
+var gameErrors = []struct {
+	err    error
+	status int
+}{
+	// a concurrent change, the client should refetch and retry
+	{core.VersionConflictError, http.StatusConflict},
+	{core.PlayerInSessionNotFoundError, http.StatusForbidden},
+	{core.NotPlayersTurnError, http.StatusConflict},
+	{core.SessionFinishedError, http.StatusConflict},
+	{core.NoBridgeError, http.StatusConflict},
+	{core.DeckExhaustedError, http.StatusConflict},
+	{core.MatchFinishedError, http.StatusConflict},
+	{core.RoundNotFinishedError, http.StatusConflict},
+	{core.IllegalMoveError, http.StatusUnprocessableEntity},
+	{core.SuitDemandError, http.StatusUnprocessableEntity},
+	{core.CardNotFoundError, http.StatusUnprocessableEntity},
+	{core.NotEnoughCardsError, http.StatusUnprocessableEntity},
+}
+
+// renderGameError tells the client which rule its action broke,
+// anything else is a failure of the server
+func renderGameError(w http.ResponseWriter, r *http.Request, err error) {
+	for _, known := range gameErrors {
+		if errors.Is(err, known.err) {
+			renderError(w, r, known.status, known.err, err)
+			return
+		}
+	}
+	renderError(w, r, http.StatusInternalServerError, ErrServerInternal, err)
+}
+
+// renderPolicyError refuses a request the policy has not allowed, with 403 if the user
+// may not do it and with notFound if what the user asks about is not there
+func renderPolicyError(w http.ResponseWriter, r *http.Request, notFound error, err error) {
+	if errors.Is(err, core.NotAllowedError) {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, err)
+		return
+	}
+	renderError(w, r, http.StatusNotFound, notFound, err)
+}
+
+// renderValidationError answers 422 with what is wrong with each field
+func renderValidationError(w http.ResponseWriter, r *http.Request, invalid *core.ValidationError) {
+	log.Error(invalid)
+	render.Render(w, r, ErrResponse{
+		Message: ErrServerValidation.Error(),
+		Fields:  invalid.Fields,
+		Code:    http.StatusUnprocessableEntity,
+	})
+}
+
 func renderError(w http.ResponseWriter, r *http.Request, code int, message error, err error) {
 	if err != nil {
 		log.Error(err)
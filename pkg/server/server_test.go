@@ -0,0 +1,714 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mrbttf/bridge-server/pkg/config"
+	"github.com/mrbttf/bridge-server/pkg/core/services/auth"
+	"github.com/mrbttf/bridge-server/pkg/core/services/match"
+	"github.com/mrbttf/bridge-server/pkg/core/services/policy"
+	"github.com/mrbttf/bridge-server/pkg/core/services/room"
+	"github.com/mrbttf/bridge-server/pkg/core/services/session"
+	"github.com/mrbttf/bridge-server/pkg/events"
+	"github.com/mrbttf/bridge-server/pkg/mail"
+	"github.com/mrbttf/bridge-server/pkg/ratelimit"
+	"github.com/mrbttf/bridge-server/pkg/repositories/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassword = "correct horse"
+
+// newTestServer returns a server on memory storage with cfg for everything else
+func newTestServer(cfg config.Config) *Server {
+	cfg.Storage = config.StorageMemory
+	store := memory.NewStore()
+	repos := store.Repositories()
+	sessionEvents := events.NewSessionBus()
+	roomEvents := events.NewRoomBus()
+	roomService := room.New(repos.Rooms, repos.Users, roomEvents)
+	sessionService := session.New(store, sessionEvents, roomService)
+	return New(
+		sessionService,
+		roomService,
+		auth.New(
+			store,
+			repos.Users,
+			repos.Tokens,
+			repos.ActionTokens,
+			mail.NewFileMailer(cfg.MailDir, "test@bridge.test"),
+			[]byte("test"),
+			cfg.AccessTokenLifetime,
+			"https://bridge.test",
+		),
+		match.New(store, sessionService),
+		policy.New(repos.Users, repos.Rooms, repos.Sessions, repos.Matches),
+		sessionEvents,
+		roomEvents,
+		ratelimit.NewMemoryStore(),
+		cfg,
+	)
+}
+
+// doRequest sends body as JSON with the token in the Authorization header,
+// if there is one, and decodes the response into response
+func doRequest(s *Server, method, path, token string, body any, response any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			panic(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.router.ServeHTTP(w, r)
+	if response != nil {
+		err := json.NewDecoder(w.Body).Decode(response)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return w
+}
+
+func registerAndLogin(s *Server, email, nickname string) UserResponse {
+	w := doRequest(s, http.MethodPost, "/auth/register", "", map[string]string{
+		"email":    email,
+		"password": testPassword,
+		"nickname": nickname,
+	}, nil)
+	if w.Code != http.StatusOK {
+		panic(w.Code)
+	}
+	var login authLoginResponse
+	w = doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+		"email":    email,
+		"password": testPassword,
+	}, &login)
+	if w.Code != http.StatusOK {
+		panic(w.Code)
+	}
+	return login.User
+}
+
+func TestServerGameWithoutDatabase(t *testing.T) {
+	s := newTestServer(config.Config{})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	guest := registerAndLogin(s, "guest@bridge.test", "Guest")
+
+	var created roomCreateResponse
+	w := doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var sessionCreated sessionCreateResponse
+	w = doRequest(s, http.MethodPost, "/session/create", host.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, &sessionCreated)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got sessionGetResponse
+	w = doRequest(s, http.MethodGet, "/session/"+sessionCreated.SessionID, guest.Token, nil, &got)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, guest.Id, got.Session.Player.Id)
+	assert.Len(t, got.Session.Player.Cards, 4)
+	assert.Equal(t, []OpponentResponse{{
+		Id:       host.Id,
+		Name:     "Host",
+		HandSize: 5,
+		State:    got.Session.Opponents[0].State,
+	}}, got.Session.Opponents)
+	assert.Equal(t, 36-1-5-4, got.Session.DeckSize)
+
+	w = doRequest(s, http.MethodPost, "/session/pull", guest.Token, map[string]string{
+		"session_id": sessionCreated.SessionID,
+		"player_id":  host.Id,
+	}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "a player must not act for another one")
+}
+
+func TestServerBearerAuth(t *testing.T) {
+	s := newTestServer(config.Config{})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+
+	w := doRequest(s, http.MethodPost, "/room/list", "", map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = doRequest(s, http.MethodPost, "/room/list", "wrong", map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/list", user.Token, map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/list", "", map[string]any{
+		"open":    true,
+		"user_id": user.Id,
+		"token":   user.Token,
+	}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "legacy auth must be off")
+}
+
+func TestServerLegacyAuth(t *testing.T) {
+	s := newTestServer(config.Config{LegacyAuth: true})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+
+	w := doRequest(s, http.MethodPost, "/room/create", "", map[string]string{
+		"host_id": user.Id,
+		"user_id": user.Id,
+		"token":   user.Token,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+
+	query := url.Values{"user_id": {user.Id}, "token": {user.Token}}
+	w = doRequest(s, http.MethodGet, "/auth/sessions?"+query.Encode(), "", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/list", user.Token, map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+}
+
+func TestServerAuthSessions(t *testing.T) {
+	s := newTestServer(config.Config{})
+	phone := registerAndLogin(s, "user@bridge.test", "User")
+	var login authLoginResponse
+	w := doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+		"email":    "user@bridge.test",
+		"password": testPassword,
+		"device":   "desktop",
+	}, &login)
+	assert.Equal(t, http.StatusOK, w.Code)
+	desktop := login.User
+
+	var sessions authSessionsResponse
+	w = doRequest(s, http.MethodGet, "/auth/sessions", desktop.Token, nil, &sessions)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, sessions.Sessions, 2)
+	assert.False(t, sessions.Sessions[0].Current)
+	assert.Equal(t, "desktop", sessions.Sessions[1].Device)
+	assert.True(t, sessions.Sessions[1].Current)
+
+	w = doRequest(s, http.MethodPost, "/auth/logout", desktop.Token, map[string]string{
+		"token_id": sessions.Sessions[0].Id,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/room/list", phone.Token, map[string]any{}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the phone must be logged out")
+
+	w = doRequest(s, http.MethodPost, "/auth/logout", desktop.Token, map[string]string{
+		"token_id": "unknown",
+	}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/auth/logout", desktop.Token, map[string]string{}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodGet, "/auth/sessions", desktop.Token, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	phone = registerAndLogin(s, "other@bridge.test", "Other")
+	w = doRequest(s, http.MethodPost, "/auth/logoutAll", phone.Token, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodGet, "/auth/sessions", phone.Token, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestServerAccessTokens(t *testing.T) {
+	s := newTestServer(config.Config{AccessTokenLifetime: 15 * time.Minute})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+	assert.NotEmpty(t, user.RefreshToken)
+
+	w := doRequest(s, http.MethodPost, "/room/list", user.Token, map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/room/list", user.RefreshToken, map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "a refresh token must not authenticate requests")
+
+	var refreshed authRefreshResponse
+	w = doRequest(s, http.MethodPost, "/auth/refresh", "", map[string]string{
+		"refresh_token": user.RefreshToken,
+	}, &refreshed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, user.RefreshToken, refreshed.RefreshToken)
+	w = doRequest(s, http.MethodPost, "/room/list", refreshed.Token, map[string]any{"open": true}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/auth/refresh", "", map[string]string{
+		"refresh_token": user.RefreshToken,
+	}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "a refresh token must work once")
+
+	w = doRequest(s, http.MethodPost, "/auth/logout", refreshed.Token, map[string]string{}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/auth/refresh", "", map[string]string{
+		"refresh_token": refreshed.RefreshToken,
+	}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "logging out must revoke the refresh token")
+}
+
+func TestServerPolicy(t *testing.T) {
+	s := newTestServer(config.Config{})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	guest := registerAndLogin(s, "guest@bridge.test", "Guest")
+	stranger := registerAndLogin(s, "stranger@bridge.test", "Stranger")
+
+	var created roomCreateResponse
+	doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{"room_id": created.RoomId}, nil)
+
+	w := doRequest(s, http.MethodPost, "/session/create", stranger.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only users in the room may start a session")
+	var sessionCreated sessionCreateResponse
+	w = doRequest(s, http.MethodPost, "/session/create", guest.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, &sessionCreated)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/session/close", stranger.Token, map[string]string{
+		"session_id": sessionCreated.SessionID,
+	}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = doRequest(s, http.MethodPost, "/session/close", host.Token, map[string]string{
+		"session_id": "unknown",
+	}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(s, http.MethodPost, "/session/close", host.Token, map[string]string{
+		"session_id": sessionCreated.SessionID,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/delete", guest.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the host may delete the room")
+	w = doRequest(s, http.MethodPost, "/room/delete", host.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServerLoginLockout(t *testing.T) {
+	s := newTestServer(config.Config{})
+	registerAndLogin(s, "user@bridge.test", "User")
+
+	login := func(password string) *httptest.ResponseRecorder {
+		return doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+			"email":    "user@bridge.test",
+			"password": password,
+		}, nil)
+	}
+	for i := 0; i < emailLockout.Threshold; i++ {
+		w := login("wrong")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	w := login(testPassword)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the right password must wait for the lockout too")
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	w = doRequest(s, http.MethodPost, "/auth/register", "", map[string]string{
+		"email":    "other@bridge.test",
+		"password": testPassword,
+		"nickname": "Other",
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+		"email":    "other@bridge.test",
+		"password": testPassword,
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "other accounts must not be locked")
+
+	for i := 0; i < emailLockout.Threshold; i++ {
+		w = doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+			"email":    "nobody@bridge.test",
+			"password": testPassword,
+		}, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	w = doRequest(s, http.MethodPost, "/auth/login", "", map[string]string{
+		"email":    "nobody@bridge.test",
+		"password": testPassword,
+	}, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "unknown emails must be locked like known ones")
+}
+
+func TestServerGameRateLimit(t *testing.T) {
+	s := newTestServer(config.Config{})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+
+	body := map[string]string{"session_id": "unknown"}
+	for i := 0; i < gameLimit.Requests; i++ {
+		w := doRequest(s, http.MethodPost, "/session/pull", user.Token, body, nil)
+		assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+	}
+	w := doRequest(s, http.MethodPost, "/session/pull", user.Token, body, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w = doRequest(s, http.MethodPost, "/session/nextTurn", user.Token, body, nil)
+	assert.NotEqual(t, http.StatusTooManyRequests, w.Code, "routes must be limited separately")
+	other := registerAndLogin(s, "other@bridge.test", "Other")
+	w = doRequest(s, http.MethodPost, "/session/pull", other.Token, body, nil)
+	assert.NotEqual(t, http.StatusTooManyRequests, w.Code, "users must be limited separately")
+}
+
+func TestServerRegisterValidation(t *testing.T) {
+	s := newTestServer(config.Config{})
+	registerAndLogin(s, "user@bridge.test", "User")
+
+	var response ErrResponse
+	w := doRequest(s, http.MethodPost, "/auth/register", "", map[string]string{
+		"email":    "not an email",
+		"password": "short",
+		"nickname": "User",
+	}, &response)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, map[string]string{
+		"email":    "is not an email address",
+		"password": "must be at least 8 characters long",
+		"nickname": "is taken",
+	}, response.Fields)
+}
+
+// mailedToken waits for the only email in dir, which may be sent after the
+// answer, and returns the token of the link to page in it
+func mailedToken(t *testing.T, dir, page string) string {
+	link := regexp.MustCompile(`https://bridge\.test/` + page + `\?token=(\S+)`)
+	var files []os.DirEntry
+	var msg []byte
+	for wait := time.Second; wait > 0; wait -= 10 * time.Millisecond {
+		var err error
+		files, err = os.ReadDir(dir)
+		if err != nil {
+			panic(err)
+		}
+		if len(files) == 1 {
+			msg, err = os.ReadFile(filepath.Join(dir, files[0].Name()))
+			if err != nil {
+				panic(err)
+			}
+			if link.Match(msg) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.Len(t, files, 1) {
+		return ""
+	}
+	os.Remove(filepath.Join(dir, files[0].Name()))
+	match := link.FindSubmatch(msg)
+	if !assert.NotNil(t, match, "no link to %s in %s", page, msg) {
+		return ""
+	}
+	token, err := url.QueryUnescape(string(match[1]))
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+func TestServerVerifyAndReset(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(config.Config{MailDir: dir})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+	assert.False(t, user.EmailVerified)
+	token := mailedToken(t, dir, "verify")
+
+	rr := doRequest(s, "POST", "/auth/verify", "", authVerifyRequest{Token: "wrong"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = doRequest(s, "POST", "/auth/verify", "", authVerifyRequest{Token: token}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = doRequest(s, "POST", "/auth/verify", "", authVerifyRequest{Token: token}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "the token works once")
+
+	rr = doRequest(s, "POST", "/auth/verify/resend", "", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = doRequest(s, "POST", "/auth/verify/resend", user.Token, nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mailedToken(t, dir, "verify")
+
+	rr = doRequest(s, "POST", "/auth/forgot", "", authForgotRequest{Email: "nobody@bridge.test"}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, "an unknown email is not told apart")
+	rr = doRequest(s, "POST", "/auth/forgot", "", authForgotRequest{Email: "user@bridge.test"}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	token = mailedToken(t, dir, "reset")
+
+	var errResponse ErrResponse
+	rr = doRequest(s, "POST", "/auth/reset", "", authResetRequest{Token: token, Password: "short"}, &errResponse)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, errResponse.Fields, "password")
+	rr = doRequest(s, "POST", "/auth/reset", "", authResetRequest{Token: token, Password: "another password"}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = doRequest(s, "POST", "/auth/reset", "", authResetRequest{Token: token, Password: "third password"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = doRequest(s, "GET", "/auth/sessions", user.Token, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "resetting logs out every device")
+	var login authLoginResponse
+	rr = doRequest(s, "POST", "/auth/login", "", authLoginRequest{Email: "user@bridge.test", Password: "another password"}, &login)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, login.User.EmailVerified)
+}
+
+func TestServerForgotMailFails(t *testing.T) {
+	// mails cannot be written into a directory that is not there
+	s := newTestServer(config.Config{MailDir: filepath.Join(t.TempDir(), "missing")})
+	registerAndLogin(s, "user@bridge.test", "User")
+
+	unknown := doRequest(s, "POST", "/auth/forgot", "", authForgotRequest{Email: "nobody@bridge.test"}, nil)
+	known := doRequest(s, "POST", "/auth/forgot", "", authForgotRequest{Email: "user@bridge.test"}, nil)
+	assert.Equal(t, http.StatusOK, known.Code)
+	assert.Equal(t, unknown.Code, known.Code, "a failing mail must not tell a registered email apart")
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+}
+
+func TestServerGuestClaim(t *testing.T) {
+	s := newTestServer(config.Config{MailDir: t.TempDir()})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+
+	var login authLoginResponse
+	w := doRequest(s, http.MethodPost, "/auth/guest", "", authGuestRequest{Device: "phone"}, &login)
+	assert.Equal(t, http.StatusOK, w.Code)
+	guest := login.User
+	assert.True(t, guest.Guest)
+	assert.NotEmpty(t, guest.Nickname)
+
+	var created roomCreateResponse
+	w = doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{"room_id": created.RoomId}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "guests join rooms like anyone")
+
+	var errResponse ErrResponse
+	w = doRequest(s, http.MethodPost, "/auth/claim", guest.Token, authClaimRequest{Email: "host@bridge.test", Password: testPassword}, &errResponse)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, errResponse.Fields, "email")
+	w = doRequest(s, http.MethodPost, "/auth/claim", guest.Token, authClaimRequest{Email: "guest@bridge.test", Password: testPassword, Nickname: "Friend"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodPost, "/auth/claim", guest.Token, authClaimRequest{Email: "other@bridge.test", Password: testPassword}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(s, http.MethodPost, "/auth/claim", host.Token, authClaimRequest{Email: "other@bridge.test", Password: testPassword}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "only guests claim accounts")
+
+	w = doRequest(s, http.MethodPost, "/auth/login", "", authLoginRequest{Email: "guest@bridge.test", Password: testPassword}, &login)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, guest.Id, login.User.Id)
+	assert.Equal(t, "Friend", login.User.Nickname)
+	assert.False(t, login.User.Guest)
+	var room roomGetResponse
+	w = doRequest(s, http.MethodGet, "/room/"+created.RoomId, login.User.Token, nil, &room)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, room.Room.Users, UserResponseSecure{Id: guest.Id, Nickname: "Friend"}, "the claimed account is still in the room")
+}
+
+func TestServerRoomSettings(t *testing.T) {
+	s := newTestServer(config.Config{})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	player := registerAndLogin(s, "player@bridge.test", "Player")
+	var created roomCreateResponse
+	w := doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodPost, "/room/settings", player.Token, roomSettingsRequest{RoomId: created.RoomId, DeckSize: 52}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the host changes settings")
+
+	var errResponse ErrResponse
+	w = doRequest(s, http.MethodPost, "/room/settings", host.Token, roomSettingsRequest{RoomId: created.RoomId, DeckSize: 40}, &errResponse)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, errResponse.Fields, "deck_size")
+	errResponse = ErrResponse{}
+	w = doRequest(s, http.MethodPost, "/room/settings", host.Token, roomSettingsRequest{RoomId: created.RoomId, MustLay: []string{"6", "X"}}, &errResponse)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, errResponse.Fields, "must_lay")
+	for _, effects := range []map[string]RoomEffect{
+		{"X": {Pull: 1}},
+		{"7": {Pull: -1}},
+		{"A": {Skip: 2000000000}},
+	} {
+		errResponse = ErrResponse{}
+		w = doRequest(s, http.MethodPost, "/room/settings", host.Token, roomSettingsRequest{RoomId: created.RoomId, Effects: effects}, &errResponse)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, errResponse.Fields, "effects")
+	}
+
+	var room roomGetResponse
+	doRequest(s, http.MethodGet, "/room/"+created.RoomId, host.Token, nil, &room)
+	assert.Equal(t, RoomEffect{Pull: 2, Skip: 1}, room.Room.Settings.Effects["8"], "the default effects are shown")
+	w = doRequest(s, http.MethodPost, "/room/settings", host.Token, roomSettingsRequest{
+		RoomId:     created.RoomId,
+		DeckSize:   52,
+		PlayerHand: 6,
+		Effects:    map[string]RoomEffect{"9": {Pull: 3}, "K": {Reverse: true}},
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	room = roomGetResponse{}
+	w = doRequest(s, http.MethodGet, "/room/"+created.RoomId, host.Token, nil, &room)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 52, room.Room.Settings.DeckSize)
+	assert.Equal(t, 6, room.Room.Settings.PlayerHand)
+	assert.Equal(t, map[string]RoomEffect{"9": {Pull: 3}, "K": {Reverse: true}}, room.Room.Settings.Effects)
+}
+
+func TestServerGameErrors(t *testing.T) {
+	s := newTestServer(config.Config{})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	guest := registerAndLogin(s, "guest@bridge.test", "Guest")
+
+	var created roomCreateResponse
+	doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{"room_id": created.RoomId}, nil)
+	var sessionCreated sessionCreateResponse
+	doRequest(s, http.MethodPost, "/session/create", host.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, &sessionCreated)
+	var got sessionGetResponse
+	doRequest(s, http.MethodGet, "/session/"+sessionCreated.SessionID, host.Token, nil, &got)
+	current, waiting := host, guest
+	if got.Session.CurrentPlayer == guest.Id {
+		current, waiting = guest, host
+	}
+
+	var response ErrResponse
+	w := doRequest(s, http.MethodPost, "/session/pull", waiting.Token, map[string]string{
+		"session_id": sessionCreated.SessionID,
+	}, &response)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "It is not the player's turn", response.Message)
+
+	response = ErrResponse{}
+	w = doRequest(s, http.MethodPost, "/session/lay", current.Token, map[string]string{
+		"session_id": sessionCreated.SessionID,
+		"card":       got.Session.TableTop,
+	}, &response)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "the card on the table is in nobody's hand")
+	assert.Equal(t, "Card not found", response.Message)
+}
+
+func TestServerMatchPolicy(t *testing.T) {
+	s := newTestServer(config.Config{})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	guest := registerAndLogin(s, "guest@bridge.test", "Guest")
+	stranger := registerAndLogin(s, "stranger@bridge.test", "Stranger")
+
+	var created roomCreateResponse
+	doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{"room_id": created.RoomId}, nil)
+	var matchCreated matchCreateResponse
+	w := doRequest(s, http.MethodPost, "/match/create", host.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, &matchCreated)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(s, http.MethodGet, "/match/"+matchCreated.MatchId, stranger.Token, nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the players may see the match")
+	w = doRequest(s, http.MethodGet, "/match/"+matchCreated.MatchId, guest.Token, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(s, http.MethodGet, "/match/unknown", guest.Token, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	body := map[string]string{"match_id": matchCreated.MatchId}
+	w = doRequest(s, http.MethodPost, "/match/nextRound", stranger.Token, body, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the players may deal the next round")
+	w = doRequest(s, http.MethodPost, "/match/nextRound", guest.Token, body, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "the round is still played")
+}
+
+func TestServerWebSocketAuth(t *testing.T) {
+	s := newTestServer(config.Config{AppURL: "https://bridge.test", LegacyAuth: false})
+	host := registerAndLogin(s, "host@bridge.test", "Host")
+	guest := registerAndLogin(s, "guest@bridge.test", "Guest")
+	var created roomCreateResponse
+	doRequest(s, http.MethodPost, "/room/create", host.Token, map[string]string{}, &created)
+	doRequest(s, http.MethodPost, "/room/join", guest.Token, map[string]string{"room_id": created.RoomId}, nil)
+	var sessionCreated sessionCreateResponse
+	doRequest(s, http.MethodPost, "/session/create", host.Token, map[string]string{
+		"room_id": created.RoomId,
+	}, &sessionCreated)
+
+	ts := httptest.NewServer(s.router)
+	defer ts.Close()
+	address := "ws" + strings.TrimPrefix(ts.URL, "http") + "/session/" + sessionCreated.SessionID + "/ws"
+	dial := func(address string, header http.Header) (*websocket.Conn, *http.Response, error) {
+		conn, response, err := websocket.DefaultDialer.Dial(address, header)
+		if conn != nil {
+			conn.Close()
+		}
+		return conn, response, err
+	}
+
+	_, response, err := dial(address, http.Header{
+		"Sec-Websocket-Protocol": {"bearer, " + guest.Token},
+		"Origin":                 {"https://bridge.test"},
+	})
+	assert.NoError(t, err, "a browser sends the token as a protocol")
+	assert.Equal(t, "bearer", response.Header.Get("Sec-Websocket-Protocol"))
+	_, _, err = dial(address+"?access_token="+url.QueryEscape(guest.Token), http.Header{
+		"Origin": {ts.URL},
+	})
+	assert.NoError(t, err, "or in the query, from the server's own pages")
+	_, _, err = dial(address, http.Header{"Authorization": {"Bearer " + guest.Token}})
+	assert.NoError(t, err, "native apps send the header and no origin")
+
+	_, response, err = dial(address, http.Header{
+		"Sec-Websocket-Protocol": {"bearer, " + guest.Token},
+		"Origin":                 {"https://evil.test"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode, "pages of other sites are refused")
+	_, response, err = dial(address, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
+
+func TestServerRoomEventsQueryToken(t *testing.T) {
+	s := newTestServer(config.Config{LegacyAuth: false})
+	user := registerAndLogin(s, "user@bridge.test", "User")
+	ts := httptest.NewServer(s.router)
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL + "/room/events")
+	if err != nil {
+		panic(err)
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	response, err = http.Get(ts.URL + "/room/events?access_token=" + url.QueryEscape(user.Token))
+	if err != nil {
+		panic(err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode, "an EventSource sends the token in the query")
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	doRequest(s, http.MethodPost, "/room/create", user.Token, map[string]string{}, nil)
+	lines := bufio.NewScanner(response.Body)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "event: ") {
+			assert.Equal(t, "event: room_created", lines.Text())
+			return
+		}
+	}
+	t.Fatal("the stream ended without an event")
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrbttf/bridge-server/pkg/log"
+)
+
+var (
+	ErrServerStreamingUnsupported = errors.New("Streaming is not supported")
+	ErrServerLastEventIdInvalid   = errors.New("Last-Event-ID is invalid")
+)
+
+const sseKeepAlivePeriod = 30 * time.Second
+
+// room/events godoc
+// @Summary Lobby events
+// @Description Streams Server-Sent Events about rooms: created, user joined or left, host changed, closed, deleted.
+// @Description Reconnecting with Last-Event-ID header or last_event_id query replays missed events.
+// @Tags room
+// @Produce  text/event-stream
+// @Param room_id query string false "Only events of this room"
+// @Param last_event_id query int false "Replay events after this id"
+// @Param Authorization header string false "Bearer token"
+// @Param access_token query string false "Token, for browsers whose EventSource cannot send the header"
+// @Success 200 {object} RoomEventResponse
+// @Failure 400 {object} ErrResponse
+// @Router /room/events [get]
+func (s *Server) roomEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		renderError(w, r, http.StatusInternalServerError, ErrServerStreamingUnsupported, ErrServerStreamingUnsupported)
+		return
+	}
+	lastEventId, err := getLastEventId(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, ErrServerLastEventIdInvalid, err)
+		return
+	}
+	roomId := r.URL.Query().Get("room_id")
+
+	events, cancel := s.roomEvents.Subscribe(lastEventId)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if roomId != "" && event.RoomId != roomId {
+				continue
+			}
+			data, err := json.Marshal(NewRoomEventResponse(&event))
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		case <-ticker.C:
+			// Comments keep proxies from closing an idle stream
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// getLastEventId reads the id browsers send when they reconnect, clients
+// that cannot set headers may pass it as a query parameter
+func getLastEventId(r *http.Request) (int64, error) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	if id == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(id, 10, 64)
+}
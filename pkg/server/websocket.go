@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/mrbttf/bridge-server/pkg/log"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// wsBearerProtocol is offered by browsers, which cannot set headers on a WebSocket,
+// followed by the token as another protocol: "bearer, <token>"
+const wsBearerProtocol = "bearer"
+
+// newUpgrader accepts connections from native apps, which send no Origin,
+// and from pages of the server itself, of appURL and of allowedOrigins
+func newUpgrader(appURL string, allowedOrigins []string) *websocket.Upgrader {
+	origins := make([]string, 0, len(allowedOrigins)+1)
+	if app, err := url.Parse(appURL); err == nil && app.Host != "" {
+		origins = append(origins, app.Scheme+"://"+app.Host)
+	}
+	for _, origin := range allowedOrigins {
+		origins = append(origins, strings.TrimSuffix(origin, "/"))
+	}
+	return &websocket.Upgrader{
+		Subprotocols: []string{wsBearerProtocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			parsed, err := url.Parse(origin)
+			if err != nil {
+				return false
+			}
+			return strings.EqualFold(parsed.Host, r.Host) || slices.ContainsFunc(origins, func(allowed string) bool {
+				return strings.EqualFold(allowed, origin)
+			})
+		},
+	}
+}
+
+// session/{session_id}/ws godoc
+// @Summary Session events
+// @Description Upgrades to a WebSocket pushing what happens in the session: card laid, card pulled, turn changed, game over
+// @Tags session
+// @Description Browsers send the token as the protocols "bearer" and the token, or as access_token in the query.
+// @Description Pages of other sites than the server, APP_URL and ALLOWED_ORIGINS are refused.
+// @Param session_id path string true "Session ID"
+// @Param Authorization header string false "Bearer token"
+// @Param Sec-WebSocket-Protocol header string false "bearer, <token>"
+// @Param access_token query string false "Token"
+// @Success 101 {object} SessionEventResponse
+// @Failure 403 {object} ErrResponse
+// @Failure 404 {object} ErrResponse
+// @Router /session/{session_id}/ws [get]
+func (s *Server) sessionWebSocket(w http.ResponseWriter, r *http.Request) {
+	sessionId := chi.URLParam(r, "session_id")
+	if sessionId == "" {
+		renderError(w, r, http.StatusBadRequest, ErrServerSessionIdInvalid, ErrServerSessionIdInvalid)
+		return
+	}
+
+	session, err := s.sessionService.GetSession(sessionId)
+	if err != nil {
+		renderError(w, r, http.StatusNotFound, ErrServerSessionIdNotFound, err)
+		return
+	}
+	if !session.HasPlayer(requestUserId(r)) {
+		renderError(w, r, http.StatusForbidden, ErrServerForbidden, ErrServerNotInSession)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		log.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	events, cancel := s.sessionEvents.Subscribe(sessionId)
+	defer cancel()
+
+	// Clients only listen, reading is needed to notice when they go away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(NewSessionEventResponse(&event))
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-closed:
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+}